@@ -35,7 +35,10 @@ import (
 
 	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/controller"
 	fsos "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem/os"
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
 	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/converter"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/logging"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/process"
 	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/signals"
 	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/version"
 )
@@ -46,6 +49,15 @@ var (
 	}
 	convertMode bool
 	versionMode bool
+	logFormat   string
+
+	defaultLocationRules   string
+	defaultExportedHeaders string
+	defaultVHostPolicy     string
+	protectByDefault       bool
+
+	// stderrRedirect captures glog output when --log-format=json
+	stderrRedirect *logging.Redirect
 )
 
 func main() {
@@ -64,6 +76,29 @@ func main() {
 	// https://github.com/golang/glog/pull/13
 	goflag.CommandLine.Parse([]string{})
 
+	format, err := logging.ParseFormat(logFormat)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	config.LogFormat = format
+	if format == logging.FormatJSON {
+		stderrRedirect, err = logging.RedirectStderr(logging.NewLogger(logging.Stderr(), format))
+		if err != nil {
+			glog.Fatalf("Unable to redirect standard error: %s", err)
+		}
+	}
+
+	config.Defaults, err = llngconfig.NewDefaults(defaultLocationRules, defaultExportedHeaders)
+	if err != nil {
+		fatalf("%s", err)
+	}
+
+	config.DefaultVHostPolicy, err = llngconfig.ParseDefaultVHostPolicy(defaultVHostPolicy)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	config.OptIn = !protectByDefault
+
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
 
@@ -74,7 +109,7 @@ func main() {
 	glog.Infof(version.Short())
 
 	if convertMode {
-		err := converter.Run(config.ConfigMapName, os.Stdin, os.Stdout)
+		err = converter.Run(config.ConfigMapName, os.Stdin, os.Stdout)
 		if err != nil {
 			glog.Error(err)
 			os.Exit(1)
@@ -84,12 +119,12 @@ func main() {
 
 	cfg, err := clientcmd.BuildConfigFromFlags(config.APIServerHost, config.KubeConfigFile)
 	if err != nil {
-		glog.Fatalf("Error building kubeconfig: %s", err.Error())
+		fatalf("Error building kubeconfig: %s", err.Error())
 	}
 
 	config.Client, err = kubernetes.NewForConfig(cfg)
 	if err != nil {
-		glog.Fatalf("Error building kubernetes clientset: %s", err.Error())
+		fatalf("Error building kubernetes clientset: %s", err.Error())
 	}
 
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(config.Client, time.Second*30)
@@ -99,8 +134,24 @@ func main() {
 	go kubeInformerFactory.Start(stopCh)
 
 	if err = ingressController.Run(stopCh); err != nil {
-		glog.Fatalf("Error running controller: %s", err.Error())
+		fatalf("Error running controller: %s", err.Error())
+	}
+	if stderrRedirect != nil {
+		glog.Flush()
+		stderrRedirect.Close()
+	}
+}
+
+// fatalf logs a fatal error and exits. Unlike glog.Fatalf, it waits for
+// redirected output to be written
+func fatalf(format string, args ...interface{}) {
+	if stderrRedirect == nil {
+		glog.Fatalf(format, args...)
 	}
+	glog.Errorf(format, args...)
+	glog.Flush()
+	stderrRedirect.Close()
+	os.Exit(255)
 }
 
 func init() {
@@ -112,6 +163,20 @@ func init() {
 	flag.StringVar(&config.Namespace, "watch-namespace", corev1.NamespaceAll, "Namespace to watch for Ingress. Default is to watch all namespaces")
 	flag.BoolVar(&config.ForceNamespaceIsolation, "force-namespace-isolation", false, "Force namespace isolation. This flag is required to avoid the reference of secrets or configmaps located in a different namespace than the specified in the flag --watch-namespace")
 	flag.StringVar(&config.LemonLDAPConfigurationDirectory, "lemonldap-ng-configuration-directory", "/var/lib/lemonldap-ng/conf", "LemonLDAP::NG configuration directory")
+	flag.StringVar(&config.LogosDirectory, "logos-directory", "", "Portal directory of application logos (e.g. /usr/share/lemonldap-ng/portal/htdocs/static/common/apps), where logos from ConfigMaps or backend favicons are written. Empty disables custom logos")
+	flag.DurationVar(&config.ProcessMinBackoff, "process-min-backoff", process.DefaultMinBackoff, "Delay before restarting the LemonLDAP::NG process after a first failure, doubled after each consecutive failure")
+	flag.DurationVar(&config.ProcessMaxBackoff, "process-max-backoff", process.DefaultMaxBackoff, "Maximum delay between two restarts of the LemonLDAP::NG process")
+	flag.IntVar(&config.ProcessCrashLoopThreshold, "process-crash-loop-threshold", process.DefaultCrashLoopThreshold, "Number of consecutive LemonLDAP::NG process failures before the controller exits")
+	flag.DurationVar(&config.ProcessGracePeriod, "process-grace-period", process.DefaultGracePeriod, "Delay given to the LemonLDAP::NG process to exit after SIGTERM on shutdown, before it is killed. Should be lower than the Pod terminationGracePeriodSeconds")
+	flag.IntVar(&config.HealthzPort, "healthz-port", 10264, "Port to serve the /healthz, /readyz and /metrics endpoints on. 0 disables the endpoints")
+	flag.DurationVar(&config.ReconcileTimeout, "reconcile-timeout", controller.DefaultReconcileTimeout, "Duration after which a running event handler is considered stuck by /healthz")
+	flag.BoolVar(&protectByDefault, "protect-by-default", true, "Protect Ingresses without enabled annotation. When false, only Ingresses annotated with enabled: \"true\" are protected")
+	flag.StringVar(&config.AnnotationsPrefix, "annotations-prefix", llngconfig.DefaultAnnotationsPrefix, "Prefix of the Ingress and Namespace annotations. Annotations are read from "+llngconfig.AnnotationsVersion+".<prefix>/<name>, or from the deprecated <prefix>/<name>")
+	flag.StringVar(&defaultLocationRules, "default-location-rules", "", "Default location rules (YAML or JSON) of Ingresses without location-rules annotation. Overridden by the ConfigMap and Namespace annotations")
+	flag.StringVar(&defaultExportedHeaders, "default-exported-headers", "", "Default exported headers (YAML or JSON) of Ingresses without exported-headers annotation. Overridden by the ConfigMap and Namespace annotations")
+	flag.StringVar(&defaultVHostPolicy, "default-vhost-policy", llngconfig.DefaultVHostMerge, "Handling of host-less Ingress rules, sharing the default vhost: merge their location rules and headers, keep the first Ingress only (in namespace/name order), or deny them")
+	flag.StringSliceVar(&config.DeniedHeaderAttributes, "denied-header-attributes", llngconfig.DefaultDeniedHeaderAttributes, "Session attributes which can not be exported in headers")
+	flag.StringVar(&logFormat, "log-format", string(logging.FormatText), "Log format of the controller and LemonLDAP::NG process output: text or json")
 	flag.BoolVar(&convertMode, "convert", false, "Convert lmConf-n.js from standard input to ConfigMap")
 	flag.BoolVar(&versionMode, "version", false, "Shows release information about the LemonLDAP::NG controller")
 }
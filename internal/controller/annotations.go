@@ -0,0 +1,57 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/golang/glog"
+
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+)
+
+// normalizeAnnotations returns the controller annotations of an object,
+// versioned names first. Legacy names are logged once per object, until it is
+// deleted
+func (c *LemonLDAPNGController) normalizeAnnotations(kind, name string, annotations map[string]string) map[string]string {
+	normalized, deprecated := llngconfig.NormalizeAnnotations(annotations, c.controllerConfig.AnnotationsPrefix)
+	if len(deprecated) == 0 {
+		return normalized
+	}
+	c.deprecationsLock.Lock()
+	defer c.deprecationsLock.Unlock()
+	key := kind + " " + name
+	logged, ok := c.deprecationsLogged[key]
+	if !ok {
+		logged = make(map[string]bool)
+		c.deprecationsLogged[key] = logged
+	}
+	for _, d := range deprecated {
+		if logged[d.Legacy] {
+			continue
+		}
+		logged[d.Legacy] = true
+		glog.Warningf("%s %s uses deprecated annotation %s, use %s instead", kind, name, d.Legacy, d.Replacement)
+	}
+	return normalized
+}
+
+// forgetDeprecations forgets the deprecated annotations logged for a deleted
+// object
+func (c *LemonLDAPNGController) forgetDeprecations(kind, name string) {
+	c.deprecationsLock.Lock()
+	defer c.deprecationsLock.Unlock()
+	delete(c.deprecationsLogged, kind+" "+name)
+}
@@ -25,35 +25,93 @@ import (
 	"gopkg.in/yaml.v2"
 
 	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+)
+
+// Reserved ConfigMap keys, holding controller settings instead of
+// LemonLDAP::NG configuration overrides
+const (
+	defaultLocationRulesKey   = "defaultLocationRules.yaml"
+	defaultExportedHeadersKey = "defaultExportedHeaders.yaml"
+	categoriesKey             = "categories.yaml"
+	externalApplicationsKey   = "externalApplications.yaml"
 )
 
-func (c *LemonLDAPNGController) parseConfigMap(obj interface{}) (namespace string, name string, match bool, overrides map[string]interface{}, err error) {
+// configMapSettings are the settings read from the controller ConfigMap
+type configMapSettings struct {
+	overrides            map[string]interface{}
+	defaults             llngconfig.Defaults
+	categories           map[string]*llngconfig.Category
+	externalApplications []*llngconfig.Application
+}
+
+func (c *LemonLDAPNGController) parseConfigMap(obj interface{}) (namespace string, name string, match bool, settings configMapSettings, err error) {
 	configMapObj := obj.(*corev1.ConfigMap)
 	configMapKey := fmt.Sprintf("%s/%s", configMapObj.Namespace, configMapObj.Name)
 	if configMapKey != c.controllerConfig.ConfigMapName {
-		return configMapObj.Namespace, configMapObj.Name, false, nil, nil
+		return configMapObj.Namespace, configMapObj.Name, false, settings, nil
+	}
+	// Invalid defaults would fall back to less restrictive ones: the whole
+	// ConfigMap is rejected
+	settings.defaults, err = llngconfig.NewDefaults(configMapObj.Data[defaultLocationRulesKey], configMapObj.Data[defaultExportedHeadersKey])
+	if err != nil {
+		return configMapObj.Namespace, configMapObj.Name, true, settings, fmt.Errorf("Unable to decode defaults in ConfigMap %s, keeping the previous settings: %s", configMapKey, err)
+	}
+	// Invalid categories would drop the ones of the applied settings
+	settings.categories, err = llngconfig.ParseCategories(configMapObj.Data[categoriesKey])
+	if err != nil {
+		return configMapObj.Namespace, configMapObj.Name, true, settings, fmt.Errorf("Unable to decode categories in ConfigMap %s, keeping the previous settings: %s", configMapKey, err)
+	}
+	// Invalid external applications would be deleted from the portal
+	settings.externalApplications, err = llngconfig.ParseExternalApplications(configMapObj.Data[externalApplicationsKey])
+	if err != nil {
+		return configMapObj.Namespace, configMapObj.Name, true, settings, fmt.Errorf("Unable to decode external applications in ConfigMap %s, keeping the previous settings: %s", configMapKey, err)
+	}
+	for _, application := range settings.externalApplications {
+		application.Owner = llngconfig.ConfigMapOwnerPrefix + configMapKey
 	}
-	overrides = make(map[string]interface{})
+	err = nil
+	settings.overrides = make(map[string]interface{})
 	for k, v := range configMapObj.Data {
-		if strings.HasSuffix(k, ".yaml") {
+		if k == defaultLocationRulesKey || k == defaultExportedHeadersKey || k == categoriesKey || k == externalApplicationsKey {
+			continue
+		} else if strings.HasSuffix(k, ".yaml") {
 			vUnmarshaled := make(map[string]interface{})
 			err = yaml.Unmarshal([]byte(v), &vUnmarshaled)
 			if err != nil {
 				glog.Errorf("Unable to decode key %s in ConfigMap %s: %s", k, configMapKey, err)
 			}
-			overrides[strings.TrimSuffix(k, ".yaml")] = vUnmarshaled
+			settings.overrides[strings.TrimSuffix(k, ".yaml")] = vUnmarshaled
 		} else if strings.Contains(k, ".") {
 			glog.Errorf("Unsupported suffix for key %s in ConfigMap %s: %s", k, configMapKey, "Use .yaml or none")
 		} else {
-			overrides[k] = v
+			settings.overrides[k] = v
 		}
 	}
-	return configMapObj.Namespace, configMapObj.Name, true, overrides, nil
+	return configMapObj.Namespace, configMapObj.Name, true, settings, nil
+}
+
+// applyConfigMapSettings replaces the applied ConfigMap settings by the
+// current ones
+func (c *LemonLDAPNGController) applyConfigMapSettings(cur configMapSettings) {
+	old := c.configMapSettings
+	c.llngConfig.SetOverrides(cur.overrides)
+	c.llngConfig.SetCategories(cur.categories)
+	c.llngConfig.DeleteApplications(old.externalApplications)
+	c.llngConfig.AddApplications(cur.externalApplications)
+	c.setConfigMapDefaults(cur.defaults)
+	c.configMapSettings = cur
 }
 
 func (c *LemonLDAPNGController) configMapAdded(obj interface{}) {
-	namespace, name, match, overrides, err := c.parseConfigMap(obj)
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	namespace, name, match, settings, err := c.parseConfigMap(obj)
 	if !match {
+		c.resyncConfigMapReferences(namespace, name)
 		return
 	}
 	if err != nil {
@@ -61,7 +119,7 @@ func (c *LemonLDAPNGController) configMapAdded(obj interface{}) {
 		return
 	}
 	glog.Infof("A ConfigMap was added: %s/%s", namespace, name)
-	c.llngConfig.SetOverrides(overrides)
+	c.applyConfigMapSettings(settings)
 	err = c.llngConfig.Save() // FIXME async + batch
 	if err != nil {
 		glog.Error(err)
@@ -70,17 +128,17 @@ func (c *LemonLDAPNGController) configMapAdded(obj interface{}) {
 }
 
 func (c *LemonLDAPNGController) configMapDeleted(obj interface{}) {
-	namespace, name, match, _, err := c.parseConfigMap(obj)
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	namespace, name, match, _, _ := c.parseConfigMap(obj)
 	if !match {
-		return
-	}
-	if err != nil {
-		// glog.Error(err)
+		c.resyncConfigMapReferences(namespace, name)
 		return
 	}
 	glog.Infof("A ConfigMap was deleted: %s/%s", namespace, name)
-	c.llngConfig.SetOverrides(make(map[string]interface{}))
-	err = c.llngConfig.Save() // FIXME async + batch
+	c.applyConfigMapSettings(configMapSettings{overrides: make(map[string]interface{})})
+	err := c.llngConfig.Save() // FIXME async + batch
 	if err != nil {
 		glog.Error(err)
 		return
@@ -88,23 +146,94 @@ func (c *LemonLDAPNGController) configMapDeleted(obj interface{}) {
 }
 
 func (c *LemonLDAPNGController) configMapUpdated(old, cur interface{}) {
-	_, _, oldMatch, oldOverrides, _ := c.parseConfigMap(old)
-	curNamespace, curName, curMatch, curOverrides, curErr := c.parseConfigMap(cur)
-	if !curMatch && !oldMatch {
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	curNamespace, curName, curMatch, curSettings, curErr := c.parseConfigMap(cur)
+	if !curMatch {
+		oldConfigMap, curConfigMap := old.(*corev1.ConfigMap), cur.(*corev1.ConfigMap)
+		if !reflect.DeepEqual(oldConfigMap.Data, curConfigMap.Data) || !reflect.DeepEqual(oldConfigMap.BinaryData, curConfigMap.BinaryData) {
+			c.resyncConfigMapReferences(curNamespace, curName)
+		}
 		return
 	}
 	if curErr != nil {
 		glog.Error(curErr)
 		return
 	}
-	if reflect.DeepEqual(oldOverrides, curOverrides) {
+	if reflect.DeepEqual(c.configMapSettings, curSettings) {
 		return
 	}
 	glog.Infof("A ConfigMap was updated: %s/%s", curNamespace, curName)
-	c.llngConfig.SetOverrides(curOverrides)
+	c.applyConfigMapSettings(curSettings)
 	err := c.llngConfig.Save() // FIXME async + batch
 	if err != nil {
 		glog.Error(err)
 		return
 	}
 }
+
+// configMapKey returns the content of a key of a ConfigMap, binary or not
+func (c *LemonLDAPNGController) configMapKey(namespace, name, key string) ([]byte, error) {
+	obj, exists, err := c.configMapCacheStore.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("ConfigMap %s/%s not found", namespace, name)
+	}
+	configMapObj := obj.(*corev1.ConfigMap)
+	if content, ok := configMapObj.BinaryData[key]; ok {
+		return content, nil
+	}
+	data, ok := configMapObj.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("Key %s not found in ConfigMap %s/%s", key, namespace, name)
+	}
+	return []byte(data), nil
+}
+
+// resyncConfigMapReferences updates the Ingresses reading SAML metadata or
+// logos from a ConfigMap which changed. handlersLock must be held
+func (c *LemonLDAPNGController) resyncConfigMapReferences(namespace, name string) {
+	updated := false
+	for _, obj := range c.ingressCacheStore.List() {
+		ingressObj := obj.(*extensionsv1beta1.Ingress)
+		if ingressObj.Namespace != namespace || !c.referencesConfigMap(ingressObj, name) {
+			continue
+		}
+		glog.Infof("A ConfigMap of Ingress %s/%s changed: %s", ingressObj.Namespace, ingressObj.Name, name)
+		c.updateIngress(obj, obj, c.defaults(namespace))
+		updated = true
+	}
+	if !updated {
+		return
+	}
+	err := c.llngConfig.Save() // FIXME async + batch
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+}
+
+// referencesConfigMap returns true when the Ingress reads its SAML metadata
+// or application logos from the ConfigMap name of its namespace
+func (c *LemonLDAPNGController) referencesConfigMap(ingressObj *extensionsv1beta1.Ingress, name string) bool {
+	annotations := c.normalizeAnnotations("Ingress", ingressObj.Namespace+"/"+ingressObj.Name, ingressObj.GetAnnotations())
+	if in, ok := annotations[c.controllerConfig.AnnotationsPrefix+"/saml-sp"]; ok {
+		spec, err := llngconfig.ParseSAMLServiceProviderSpec(in)
+		if err == nil && strings.HasPrefix(spec.Metadata, name+"/") {
+			return true
+		}
+	}
+	_, _, _, applications, err := c.parseIngress(ingressObj)
+	if err != nil {
+		return false
+	}
+	for _, application := range applications {
+		if strings.HasPrefix(application.Logo, llngconfig.LogoConfigMapPrefix+name+"/") {
+			return true
+		}
+	}
+	return false
+}
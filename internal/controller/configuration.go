@@ -22,6 +22,8 @@ import (
 	clientset "k8s.io/client-go/kubernetes"
 
 	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem"
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/logging"
 )
 
 // Configuration stores lemonldap-ng-controller configuration
@@ -40,6 +42,37 @@ type Configuration struct {
 
 	FS                              filesystem.Filesystem
 	LemonLDAPConfigurationDirectory string
+	// LogosDirectory is the portal directory of application logos, where
+	// custom logos are written. Empty disables custom logos
+	LogosDirectory string
 
 	Command []string
+
+	ProcessMinBackoff         time.Duration
+	ProcessMaxBackoff         time.Duration
+	ProcessCrashLoopThreshold int
+	ProcessGracePeriod        time.Duration
+
+	HealthzPort      int
+	ReconcileTimeout time.Duration
+
+	LogFormat logging.Format
+
+	// OptIn only protects Ingresses annotated with enabled: "true". By
+	// default, Ingresses without enabled annotation are protected
+	OptIn bool
+
+	// DefaultVHostPolicy tells how host-less Ingress rules are handled
+	DefaultVHostPolicy string
+
+	// DeniedHeaderAttributes are the session attributes which can not be
+	// exported in headers
+	DeniedHeaderAttributes []string
+
+	// AnnotationsPrefix is the prefix of Ingress and Namespace annotations
+	AnnotationsPrefix string
+
+	// Defaults are the default location rules and exported headers set by
+	// flags
+	Defaults llngconfig.Defaults
 }
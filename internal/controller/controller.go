@@ -17,17 +17,26 @@ limitations under the License.
 package controller
 
 import (
+	"sync"
+	"time"
+
 	"github.com/golang/glog"
 
 	corev1 "k8s.io/api/core/v1"
 	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/process"
 )
 
 // LemonLDAPNGController watches the kubernetes api for changes to ingresses
@@ -38,9 +47,38 @@ type LemonLDAPNGController struct {
 	ingressCacheController   cache.Controller
 	configMapCacheStore      cache.Store
 	configMapCacheController cache.Controller
+	namespaceCacheStore      cache.Store
+	namespaceCacheController cache.Controller
+	supervisor               *process.Supervisor
+	recorder                 record.EventRecorder
+	logos                    *llngconfig.Logos
+
+	// configMapSettings are the settings applied from the controller
+	// ConfigMap
+	configMapSettings configMapSettings
+
+	// configMapDefaults and namespaceDefaults are the defaults set by the
+	// ConfigMap and by Namespace annotations
+	configMapDefaults llngconfig.Defaults
+	namespaceDefaults map[string]llngconfig.Defaults
+	defaultsLock      sync.RWMutex
+
+	// deprecationsLogged records the deprecated annotations already logged,
+	// by object
+	deprecationsLogged map[string]map[string]bool
+	deprecationsLock   sync.Mutex
 
 	// llngErrCh channel used to detect errors with the LemonLDAP::NG processes
 	llngErrCh chan error
+
+	// reconciles are the start times of the running event handlers, by id
+	reconciles      map[uint64]time.Time
+	nextReconcileID uint64
+	reconcileLock   sync.RWMutex
+
+	// handlersLock serializes the event handlers, including the one of
+	// fetched logos, which all update the LemonLDAP::NG configuration
+	handlersLock sync.Mutex
 }
 
 // Run will set up the event handlers for types we are interested in, as well
@@ -54,15 +92,50 @@ func (c *LemonLDAPNGController) Run(stopCh <-chan struct{}) error {
 	glog.Info("Starting LemonLDAP::NG controller")
 
 	glog.Info("Starting workers")
-	go c.ingressCacheController.Run(stopCh)
+	go c.runIngressInformer(stopCh)
 	go c.configMapCacheController.Run(stopCh)
+	go c.namespaceCacheController.Run(stopCh)
 	go c.StartProcess(stopCh)
+	go c.serveHTTP(stopCh)
+	go c.publishWhenSynced(stopCh)
+	go wait.Until(c.retryReload, 10*time.Second, stopCh)
 
 	glog.Info("Started workers")
-	<-stopCh
-	glog.Info("Shutting down workers")
+	select {
+	case <-stopCh:
+		glog.Info("Shutting down workers")
+		// wait for the LemonLDAP::NG process to terminate
+		return <-c.llngErrCh
+	case err := <-c.llngErrCh:
+		return err
+	}
+}
+
+// runIngressInformer starts the Ingress informer once the ConfigMap informer
+// is synced: Ingresses read SAML metadata and logos from ConfigMaps
+func (c *LemonLDAPNGController) runIngressInformer(stopCh <-chan struct{}) {
+	if !cache.WaitForCacheSync(stopCh, c.configMapCacheController.HasSynced) {
+		return
+	}
+	c.ingressCacheController.Run(stopCh)
+}
 
-	return nil
+// publishWhenSynced publishes the configuration once the informers are
+// synced, even when no Ingress changed it
+func (c *LemonLDAPNGController) publishWhenSynced(stopCh <-chan struct{}) {
+	if !cache.WaitForCacheSync(stopCh, c.informersSynced) {
+		return
+	}
+	if err := c.llngConfig.Publish(); err != nil {
+		glog.Error(err)
+	}
+}
+
+// retryReload reloads LemonLDAP::NG again if the last reload failed
+func (c *LemonLDAPNGController) retryReload() {
+	if err := c.llngConfig.RetryReload(); err != nil {
+		glog.Warningf("Unable to reload LemonLDAP::NG: %s", err)
+	}
 }
 
 // NewLemonLDAPNGController returns a new ingress controller
@@ -70,6 +143,32 @@ func NewLemonLDAPNGController(controllerConfig *Configuration) *LemonLDAPNGContr
 	ingressWatcher := &LemonLDAPNGController{}
 	ingressWatcher.controllerConfig = controllerConfig
 	ingressWatcher.llngConfig = llngconfig.NewConfig(controllerConfig.FS, controllerConfig.LemonLDAPConfigurationDirectory)
+	if controllerConfig.DefaultVHostPolicy == "" {
+		controllerConfig.DefaultVHostPolicy = llngconfig.DefaultVHostMerge
+	}
+	ingressWatcher.llngConfig.SetDefaultVHostPolicy(controllerConfig.DefaultVHostPolicy)
+	ingressWatcher.llngConfig.SetConflictHandler(ingressWatcher.conflictDetected)
+	if controllerConfig.LogosDirectory != "" {
+		ingressWatcher.logos = llngconfig.NewLogos(controllerConfig.FS, controllerConfig.LogosDirectory)
+		ingressWatcher.logos.SetFetchedHandler(ingressWatcher.logoFetched)
+	}
+	ingressWatcher.supervisor = newSupervisor(controllerConfig)
+	ingressWatcher.llngErrCh = make(chan error, 1)
+	ingressWatcher.namespaceDefaults = make(map[string]llngconfig.Defaults)
+	ingressWatcher.reconciles = make(map[uint64]time.Time)
+	ingressWatcher.deprecationsLogged = make(map[string]map[string]bool)
+	if controllerConfig.DeniedHeaderAttributes == nil {
+		controllerConfig.DeniedHeaderAttributes = llngconfig.DefaultDeniedHeaderAttributes
+	}
+	if controllerConfig.AnnotationsPrefix == "" {
+		controllerConfig.AnnotationsPrefix = llngconfig.DefaultAnnotationsPrefix
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: controllerConfig.Client.CoreV1().Events(corev1.NamespaceAll),
+	})
+	ingressWatcher.recorder = eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "lemonldap-ng-controller"})
 
 	watchNs := corev1.NamespaceAll
 	if controllerConfig.ForceNamespaceIsolation && controllerConfig.Namespace != corev1.NamespaceAll {
@@ -110,5 +209,28 @@ func NewLemonLDAPNGController(controllerConfig *Configuration) *LemonLDAPNGContr
 		},
 		&corev1.ConfigMap{}, controllerConfig.ResyncPeriod, mapEventHandler)
 
+	// Create informer for watching Namespaces defaults
+	nsEventHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    ingressWatcher.namespaceAdded,
+		DeleteFunc: ingressWatcher.namespaceDeleted,
+		UpdateFunc: ingressWatcher.namespaceUpdated,
+	}
+	namespaceFieldSelector := fields.Everything()
+	if controllerConfig.Namespace != corev1.NamespaceAll {
+		namespaceFieldSelector = fields.OneTermEqualSelector("metadata.name", controllerConfig.Namespace)
+	}
+	ingressWatcher.namespaceCacheStore, ingressWatcher.namespaceCacheController = cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = namespaceFieldSelector.String()
+				return controllerConfig.Client.CoreV1().Namespaces().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = namespaceFieldSelector.String()
+				return controllerConfig.Client.CoreV1().Namespaces().Watch(options)
+			},
+		},
+		&corev1.Namespace{}, controllerConfig.ResyncPeriod, nsEventHandler)
+
 	return ingressWatcher
 }
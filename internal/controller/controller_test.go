@@ -19,7 +19,10 @@ package controller
 import (
 	"flag"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,8 +33,10 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	fakeclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 
 	fakefs "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem/fake"
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
 )
 
 func buildFakeClientSet() *fakeclient.Clientset {
@@ -118,16 +123,16 @@ Index: _whatToTrace ipAddr`,
 
 func buildControllerConfig(namespace string, forceNamespaceIsolation bool) *Configuration {
 	return &Configuration{
-		APIServerHost:           "",
-		KubeConfigFile:          "",
-		Client:                  buildFakeClientSet(),
-		ResyncPeriod:            time.Hour,
-		ConfigMapName:           "test-ns/test-cm",
-		Namespace:               namespace,
-		ForceNamespaceIsolation: forceNamespaceIsolation,
-		FS: fakefs.NewFilesystem(),
+		APIServerHost:                   "",
+		KubeConfigFile:                  "",
+		Client:                          buildFakeClientSet(),
+		ResyncPeriod:                    time.Hour,
+		ConfigMapName:                   "test-ns/test-cm",
+		Namespace:                       namespace,
+		ForceNamespaceIsolation:         forceNamespaceIsolation,
+		FS:                              fakefs.NewFilesystem(),
 		LemonLDAPConfigurationDirectory: "/var/lib/lemonldap-ng/conf",
-		Command: []string{"/bin/true"},
+		Command:                         []string{"/bin/true"},
 	}
 }
 
@@ -209,7 +214,7 @@ func TestNewLemonLDAPNGController(t *testing.T) {
 			domainRE := domainNoneRE
 
 			var /* const */ applicationListNoneRE = regexp.MustCompile(`"applicationList": {},`)
-			var /* const */ applicationListTest1RE = regexp.MustCompile(`"applicationList": {\s*"10apps": {\s*"Test ingress 1": {\s*"options": {\s*"description": "Test ingress 1",\s*"display": "auto",\s*"logo": "gear.png",\s*"name": "Test ingress 1",\s*"uri": "https://test1.example.org/"\s*},\s*"type": "application"\s*},\s*"catname": "10apps",\s*"type": "category"\s*}\s*},\s*"cfgAuthor"`)
+			var /* const */ applicationListTest1RE = regexp.MustCompile(`"applicationList": {\s*"10apps": {\s*"Test ingress 1": {\s*"options": {\s*"description": "Test ingress 1",\s*"display": "auto",\s*"logo": "gear.png",\s*"name": "Test ingress 1",\s*"uri": "http://test1.example.org/"\s*},\s*"type": "application"\s*},\s*"catname": "10apps",\s*"type": "category"\s*}\s*},\s*"cfgAuthor"`)
 			applicationListRE := applicationListNoneRE
 
 			var /* const */ globalStorageOptionsNoneRE = regexp.MustCompile(`"cfgNum": \d+,\s*"exportedHeaders`)
@@ -248,6 +253,10 @@ func TestNewLemonLDAPNGController(t *testing.T) {
 				exportedHeadersRE = exportedHeadersBothRE
 				locationRulesRE = locationRulesBothRE
 			}
+			// The unchanged configuration was published once synced
+			if configNum == 1 {
+				configNum++
+			}
 
 			cfgNumRE := regexp.MustCompile(fmt.Sprintf("\"cfgNum\": %d,", configNum))
 			checkLLConfig(t, ingressController, configNum, []*regexp.Regexp{
@@ -298,3 +307,729 @@ func TestNewLemonLDAPNGController(t *testing.T) {
 		}
 	}
 }
+
+func TestHealthEndpoints(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+
+	recorder := httptest.NewRecorder()
+	ingressController.healthz(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to return %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+
+	done := ingressController.trackReconcile()
+	for id := range ingressController.reconciles {
+		ingressController.reconciles[id] = time.Now().Add(-2 * DefaultReconcileTimeout)
+	}
+	// Another handler returning doesn't hide the stuck one
+	ingressController.trackReconcile()()
+	recorder = httptest.NewRecorder()
+	ingressController.healthz(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected /healthz to return %d, got %d: %s", http.StatusInternalServerError, recorder.Code, recorder.Body)
+	}
+	done()
+	recorder = httptest.NewRecorder()
+	ingressController.healthz(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to return %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	ingressController.readyz(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to return %d, got %d: %s", http.StatusServiceUnavailable, recorder.Code, recorder.Body)
+	}
+	for _, check := range []string{"informers: not synced", "process: not running"} {
+		if !strings.Contains(recorder.Body.String(), check) {
+			t.Errorf("Expected /readyz to report %q, got %s", check, recorder.Body)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	controllerConfig.Defaults = llngconfig.Defaults{
+		LocationRules: map[string]string{"default": "$authenticationLevel >= 3"},
+	}
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-defaults",
+			Namespace: "test-ns",
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: "test3.example.org",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+					},
+				},
+			},
+		},
+	}
+	checkDefaults := func(locationRule, exportedHeader string) {
+		_, _, vhosts, _, err := ingressController.parseIngress(ingress)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		vhost := vhosts["test3.example.org"]
+		if vhost.LocationRules["default"] != locationRule || vhost.ExportedHeaders["Auth-User"] != exportedHeader {
+			t.Errorf("Expected default rule %q and Auth-User %q, got %v and %v", locationRule, exportedHeader, vhost.LocationRules, vhost.ExportedHeaders)
+		}
+	}
+
+	checkDefaults("$authenticationLevel >= 3", "$uid")
+	ingressController.setConfigMapDefaults(llngconfig.Defaults{
+		LocationRules:   map[string]string{"default": "$authenticationLevel >= 2"},
+		ExportedHeaders: map[string]string{"Auth-User": "$mail"},
+	})
+	checkDefaults("$authenticationLevel >= 2", "$mail")
+	ingressController.namespaceAdded(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-ns",
+			Annotations: map[string]string{
+				"kubernetes-controller.lemonldap-ng.org/default-location-rules": `{"default": "accept"}`,
+			},
+		},
+	})
+	checkDefaults("accept", "$mail")
+	ingress.Annotations = map[string]string{
+		"kubernetes-controller.lemonldap-ng.org/location-rules": `{"default": "deny"}`,
+	}
+	checkDefaults("deny", "$mail")
+	ingress.Annotations = nil
+	ingressController.namespaceDeleted(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-ns"}})
+	checkDefaults("$authenticationLevel >= 2", "$mail")
+}
+
+func TestDefaultsResync(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-resync",
+			Namespace: "test-ns",
+			Annotations: map[string]string{
+				"kubernetes-controller.lemonldap-ng.org/application-category": "10apps",
+				"kubernetes-controller.lemonldap-ng.org/application-name":     "Resync",
+			},
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: "test7.example.org",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+					},
+				},
+			},
+		},
+	}
+	ingressController.ingressCacheStore.Add(ingress)
+	ingressController.ingressAdded(ingress)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cm",
+			Namespace: "test-ns",
+		},
+		Data: map[string]string{
+			"defaultLocationRules.yaml": `{"default": "deny"}`,
+		},
+	}
+	ingressController.configMapAdded(configMap)
+	checkLLConfig(t, ingressController, 3, []*regexp.Regexp{
+		regexp.MustCompile(`"test7.example.org": {\s*"default": "deny"\s*}`),
+	})
+
+	// Invalid defaults are rejected, the previous ones are kept
+	invalid := configMap.DeepCopy()
+	invalid.Data = map[string]string{
+		"defaultLocationRules.yaml": `{"default": [deny`,
+		"domain":                    "example.com",
+	}
+	ingressController.configMapUpdated(configMap, invalid)
+	if rule := ingressController.defaults("test-ns").LocationRules["default"]; rule != "deny" {
+		t.Errorf("Expected the previous default rule deny, got %q", rule)
+	}
+	if lastConfigName, _, _ := ingressController.llngConfig.Last(); lastConfigName != "lmConf-3.js" {
+		t.Errorf("Expected no new configuration, got %s", lastConfigName)
+	}
+
+	// Ingresses are updated with the new defaults
+	accepted := configMap.DeepCopy()
+	accepted.Data = map[string]string{
+		"defaultLocationRules.yaml": `{"default": "accept"}`,
+	}
+	ingressController.configMapUpdated(configMap, accepted)
+	checkLLConfig(t, ingressController, 4, []*regexp.Regexp{
+		regexp.MustCompile(`"test7.example.org": {\s*"default": "accept"\s*}`),
+	})
+
+	// Ingresses rejected with the new defaults keep their previous
+	// configuration
+	denied := configMap.DeepCopy()
+	denied.Data = map[string]string{
+		"defaultExportedHeaders.yaml": `{"Auth-Password": "$_password"}`,
+	}
+	ingressController.configMapUpdated(accepted, denied)
+	if lastConfigName, _, _ := ingressController.llngConfig.Last(); lastConfigName != "lmConf-4.js" {
+		t.Errorf("Expected no new configuration, got %s", lastConfigName)
+	}
+}
+
+func TestAnnotationsPrefix(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	controllerConfig.AnnotationsPrefix = "llng2.example.org"
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-prefix",
+			Namespace: "test-ns",
+			Annotations: map[string]string{
+				"kubernetes-controller.lemonldap-ng.org/location-rules": `{"default": "deny"}`,
+				"llng2.example.org/location-rules":                      `{"default": "skip"}`,
+				"v1.llng2.example.org/location-rules":                   `{"default": "unprotect"}`,
+				"llng2.example.org/exported-headers":                    `{"Auth-User": "$mail"}`,
+			},
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: "test3.example.org",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+					},
+				},
+			},
+		},
+	}
+	_, _, vhosts, _, err := ingressController.parseIngress(ingress)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	vhost := vhosts["test3.example.org"]
+	if vhost.LocationRules["default"] != "unprotect" || vhost.ExportedHeaders["Auth-User"] != "$mail" {
+		t.Errorf("Expected versioned location rules and legacy exported headers, got %v and %v", vhost.LocationRules, vhost.ExportedHeaders)
+	}
+	if !ingressController.deprecationsLogged["Ingress test-ns/test-prefix"]["llng2.example.org/exported-headers"] {
+		t.Errorf("Expected deprecated exported-headers annotation to be logged, got %v", ingressController.deprecationsLogged)
+	}
+	ingressController.ingressDeleted(ingress)
+	if _, ok := ingressController.deprecationsLogged["Ingress test-ns/test-prefix"]; ok {
+		t.Errorf("Expected deprecated annotations of deleted Ingress to be forgotten, got %v", ingressController.deprecationsLogged)
+	}
+}
+
+func TestOptIn(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	controllerConfig.OptIn = true
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-opt-in",
+			Namespace: "test-ns",
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: "test3.example.org",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+					},
+				},
+			},
+		},
+	}
+	for enabled, expected := range map[string]int{"": 0, "false": 0, "true": 1} {
+		ingress.Annotations = map[string]string{}
+		if enabled != "" {
+			ingress.Annotations["kubernetes-controller.lemonldap-ng.org/enabled"] = enabled
+		}
+		_, _, vhosts, _, err := ingressController.parseIngress(ingress)
+		if err != nil {
+			t.Errorf("%s", err)
+		}
+		if len(vhosts) != expected {
+			t.Errorf("Expected %d vhosts with enabled=%q, got %v", expected, enabled, vhosts)
+		}
+	}
+	ingress.Annotations = map[string]string{"kubernetes-controller.lemonldap-ng.org/enabled": "maybe"}
+	if _, _, _, _, err := ingressController.parseIngress(ingress); err == nil {
+		t.Errorf("Expected invalid enabled error")
+	}
+}
+
+func TestWildcardHosts(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	controllerConfig.DefaultVHostPolicy = llngconfig.DefaultVHostDeny
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	ruleValue := extensionsv1beta1.IngressRuleValue{
+		HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+	}
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-wildcard",
+			Namespace: "test-ns",
+			Annotations: map[string]string{
+				"kubernetes-controller.lemonldap-ng.org/application-category": "10apps",
+				"kubernetes-controller.lemonldap-ng.org/application-name":     "Wildcard",
+			},
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			TLS: []extensionsv1beta1.IngressTLS{
+				{Hosts: []string{"*.example.org"}, SecretName: "wildcard-tls"},
+			},
+			Rules: []extensionsv1beta1.IngressRule{
+				{Host: "*.example.org", IngressRuleValue: ruleValue},
+				{Host: "App.example.org", IngressRuleValue: ruleValue},
+				{Host: "", IngressRuleValue: ruleValue},
+			},
+		},
+	}
+	_, _, vhosts, applications, err := ingressController.parseIngress(ingress)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(vhosts) != 2 {
+		t.Errorf("Expected wildcard and app vhosts only, got %v", vhosts)
+	}
+	for _, serverName := range []string{"*.example.org", "app.example.org"} {
+		if vhost, ok := vhosts[serverName]; !ok || !vhost.TLS || vhost.TLSSecret != "test-ns/wildcard-tls" {
+			t.Errorf("Expected TLS vhost %s, got %+v", serverName, vhost)
+		}
+	}
+	if len(applications) != 1 || applications[0].URI != "https://app.example.org/" {
+		t.Errorf("Expected application on app.example.org, got %+v", applications)
+	}
+
+	// A TLS section without hosts applies to all the hosts
+	ingress.Spec.TLS = []extensionsv1beta1.IngressTLS{{SecretName: "default-tls"}}
+	if _, _, vhosts, _, err = ingressController.parseIngress(ingress); err != nil {
+		t.Errorf("%s", err)
+	}
+	for _, serverName := range []string{"*.example.org", "app.example.org"} {
+		if vhost, ok := vhosts[serverName]; !ok || !vhost.TLS || vhost.TLSSecret != "test-ns/default-tls" {
+			t.Errorf("Expected TLS vhost %s, got %+v", serverName, vhost)
+		}
+	}
+
+	ingress.Spec.Rules = []extensionsv1beta1.IngressRule{
+		{Host: "app*.example.org", IngressRuleValue: ruleValue},
+	}
+	if _, _, _, _, err = ingressController.parseIngress(ingress); err == nil {
+		t.Errorf("Expected invalid host error")
+	}
+}
+
+func TestTLSSecretEvents(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	recorder := record.NewFakeRecorder(10)
+	ingressController.recorder = recorder
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-tls",
+			Namespace: "test-ns",
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			TLS: []extensionsv1beta1.IngressTLS{
+				{Hosts: []string{"test9.example.org"}, SecretName: "test9-tls"},
+			},
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: "test9.example.org",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+					},
+				},
+			},
+		},
+	}
+	ingressController.ingressAdded(ingress)
+	select {
+	case event := <-recorder.Events:
+		expected := "Warning TLSSecretMissing TLS Secret test9-tls is missing (hosts test9.example.org)"
+		if event != expected {
+			t.Errorf("Expected event %q, got %q", expected, event)
+		}
+	default:
+		t.Errorf("Expected a missing TLS Secret event")
+	}
+
+	controllerConfig.Client.CoreV1().Secrets("test-ns").Create(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test9-tls",
+			Namespace: "test-ns",
+		},
+	})
+	ingressController.ingressUpdated(ingress, ingress)
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Expected no event, got %q", event)
+	default:
+	}
+}
+
+func TestApplicationConflictEvents(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	recorder := record.NewFakeRecorder(10)
+	ingressController.recorder = recorder
+	newIngress := func(namespace, host string) *extensionsv1beta1.Ingress {
+		return &extensionsv1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "wiki",
+				Namespace: namespace,
+				Annotations: map[string]string{
+					"kubernetes-controller.lemonldap-ng.org/application-category": "10apps",
+					"kubernetes-controller.lemonldap-ng.org/application-name":     "Wiki",
+				},
+			},
+			Spec: extensionsv1beta1.IngressSpec{
+				Rules: []extensionsv1beta1.IngressRule{
+					{
+						Host: host,
+						IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+							HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+						},
+					},
+				},
+			},
+		}
+	}
+	ingress1 := newIngress("ns1", "wiki1.example.org")
+	ingress2 := newIngress("ns2", "wiki2.example.org")
+	for _, ingress := range []*extensionsv1beta1.Ingress{ingress1, ingress2} {
+		ingressController.ingressCacheStore.Add(ingress)
+		ingressController.ingressAdded(ingress)
+	}
+	select {
+	case event := <-recorder.Events:
+		expected := "Warning ApplicationConflict Application 10apps/Wiki is already defined by ns1/wiki, ignoring it"
+		if event != expected {
+			t.Errorf("Expected event %q, got %q", expected, event)
+		}
+	default:
+		t.Errorf("Expected an application conflict event")
+	}
+
+	ingressController.ingressCacheStore.Delete(ingress1)
+	ingressController.ingressDeleted(ingress1)
+	if conflicts := ingressController.llngConfig.Conflicts(); len(conflicts) != 0 {
+		t.Errorf("Expected no conflict, got %v", conflicts)
+	}
+	lmConf, err := controllerConfig.FS.ReadFile(controllerConfig.LemonLDAPConfigurationDirectory + "/lmConf-4.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !strings.Contains(string(lmConf), `"uri": "http://wiki2.example.org/"`) {
+		t.Errorf("Expected the application of ns2/wiki, got %s", lmConf)
+	}
+}
+
+func TestCustomLogos(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	controllerConfig.LogosDirectory = "/var/lib/lemonldap-ng/conf"
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	ingressController.configMapCacheStore.Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "logos",
+			Namespace: "test-ns",
+		},
+		BinaryData: map[string][]byte{"app.png": []byte("PNG")},
+	})
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-logos",
+			Namespace: "test-ns",
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: "test4.example.org",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{
+							Paths: []extensionsv1beta1.HTTPIngressPath{
+								{Backend: extensionsv1beta1.IngressBackend{ServiceName: "app", ServicePort: intstr.FromInt(8080)}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	applications := []*llngconfig.Application{
+		{Category: "apps", Name: "ConfigMap", Logo: "configmap:logos/app.png"},
+		{Category: "apps", Name: "Missing", Logo: "configmap:logos/missing.png"},
+		{Category: "apps", Name: "Skin", Logo: "thumbnail.png"},
+	}
+	ingressController.resolveLogos(ingress, applications)
+	for i, expected := range []string{"test-ns_logos_app.png", llngconfig.DefaultLogo, "thumbnail.png"} {
+		if applications[i].Logo != expected {
+			t.Errorf("Expected logo %s, got %s", expected, applications[i].Logo)
+		}
+	}
+	if content, err := controllerConfig.FS.ReadFile("/var/lib/lemonldap-ng/conf/test-ns_logos_app.png"); err != nil || string(content) != "PNG" {
+		t.Errorf("Expected logo content, got %q, %v", content, err)
+	}
+
+	// Logos are resolved again when their ConfigMap changes
+	ingress.Annotations = map[string]string{
+		"kubernetes-controller.lemonldap-ng.org/application-category": "apps",
+		"kubernetes-controller.lemonldap-ng.org/application-name":     "Late",
+		"kubernetes-controller.lemonldap-ng.org/application-logo":     "configmap:logos/late.png",
+	}
+	ingressController.ingressCacheStore.Add(ingress)
+	ingressController.ingressAdded(ingress)
+	checkLLConfig(t, ingressController, 2, []*regexp.Regexp{regexp.MustCompile(`"logo": "gear.png",\s*"name": "Late"`)})
+	oldLogos, _, _ := ingressController.configMapCacheStore.GetByKey("test-ns/logos")
+	logos := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "logos",
+			Namespace: "test-ns",
+		},
+		BinaryData: map[string][]byte{"app.png": []byte("PNG"), "late.png": []byte("PNG")},
+	}
+	ingressController.configMapCacheStore.Update(logos)
+	ingressController.configMapUpdated(oldLogos, logos)
+	checkLLConfig(t, ingressController, 3, []*regexp.Regexp{regexp.MustCompile(`"logo": "test-ns_logos_late.png",\s*"name": "Late"`)})
+	ingress.Annotations = nil
+
+	url, err := backendURL(ingress)
+	if err != nil || url != "http://app.test-ns.svc:8080" {
+		t.Errorf("Expected backend URL http://app.test-ns.svc:8080, got %s, %v", url, err)
+	}
+	ingress.Spec.Backend = &extensionsv1beta1.IngressBackend{ServiceName: "default", ServicePort: intstr.FromString("http")}
+	if _, err = backendURL(ingress); err == nil {
+		t.Errorf("Expected named port error")
+	}
+}
+
+func TestExternalApplications(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cm",
+			Namespace: "test-ns",
+		},
+		Data: map[string]string{
+			"domain": "example.org",
+			"externalApplications.yaml": `
+- category: tools
+  name: Chat
+  uri: https://chat.example.com/
+`,
+		},
+	}
+	_, _, match, settings, err := ingressController.parseConfigMap(configMap)
+	if !match || err != nil {
+		t.Errorf("Expected ConfigMap to match, got %v, %v", match, err)
+		return
+	}
+	if _, ok := settings.overrides["externalApplications"]; ok {
+		t.Errorf("Expected externalApplications.yaml not to be an override")
+	}
+	if len(settings.externalApplications) != 1 || settings.externalApplications[0].Owner != "ConfigMap test-ns/test-cm" {
+		t.Errorf("Expected an external application of the ConfigMap, got %v", settings.externalApplications)
+	}
+
+	ingressController.configMapAdded(configMap)
+	checkLLConfig(t, ingressController, 2, []*regexp.Regexp{
+		regexp.MustCompile(`"tools": {\s*"Chat": {\s*"options": {\s*"description": "Chat",\s*"display": "auto",\s*"logo": "gear.png",\s*"name": "Chat",\s*"uri": "https://chat.example.com/"\s*},\s*"type": "application"\s*},\s*"catname": "tools",\s*"type": "category"\s*}`),
+	})
+
+	// Invalid external applications are rejected, the previous ones are kept
+	invalid := configMap.DeepCopy()
+	invalid.Data["externalApplications.yaml"] = `
+- category: tools
+  name: Chat
+`
+	if _, _, _, _, err = ingressController.parseConfigMap(invalid); err == nil {
+		t.Errorf("Expected external application without uri to be rejected")
+	}
+	ingressController.configMapUpdated(configMap, invalid)
+	if lastConfigName, _, _ := ingressController.llngConfig.Last(); lastConfigName != "lmConf-2.js" {
+		t.Errorf("Expected no new configuration, got %s", lastConfigName)
+	}
+	if len(ingressController.configMapSettings.externalApplications) != 1 {
+		t.Errorf("Expected the previous external applications to be kept, got %v", ingressController.configMapSettings.externalApplications)
+	}
+
+	ingressController.configMapDeleted(configMap)
+	checkLLConfig(t, ingressController, 3, []*regexp.Regexp{
+		regexp.MustCompile(`"applicationList": {},`),
+	})
+}
+
+func TestOIDCRelyingParty(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-oidc",
+			Namespace: "test-ns",
+			UID:       "1234",
+			Annotations: map[string]string{
+				"kubernetes-controller.lemonldap-ng.org/oidc-rp": `{redirectPaths: [/oauth2/callback], claims: {email: mail}}`,
+			},
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: "test5.example.org",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+					},
+				},
+			},
+		},
+	}
+	ingressController.ingressAdded(ingress)
+	secret, err := controllerConfig.Client.CoreV1().Secrets("test-ns").Get("test-oidc-oidc-client", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	clientSecret := string(secret.Data["client-secret"])
+	if string(secret.Data["client-id"]) != "test-ns_test-oidc" || len(clientSecret) < 32 {
+		t.Errorf("Unexpected Secret data %v", secret.Data)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Kind != "Ingress" || secret.OwnerReferences[0].UID != "1234" {
+		t.Errorf("Expected the Secret to be owned by the Ingress, got %v", secret.OwnerReferences)
+	}
+	checkLLConfig(t, ingressController, 2, []*regexp.Regexp{
+		regexp.MustCompile(`"oidcRPMetaDataExportedVars": {\s*"test-ns_test-oidc": {\s*"email": "mail"\s*}\s*}`),
+		regexp.MustCompile(`"oidcRPMetaDataOptionsClientSecret": "` + regexp.QuoteMeta(clientSecret) + `"`),
+		regexp.MustCompile(`"oidcRPMetaDataOptionsRedirectUris": "http://test5.example.org/oauth2/callback"`),
+	})
+
+	// The client secret is kept when the Ingress is parsed again
+	rp, err := ingressController.parseOIDCRelyingParty(ingress, map[string]*llngconfig.VHost{
+		"test5.example.org": llngconfig.NewVHost("test5.example.org", nil, nil),
+	})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if err = ingressController.ensureOIDCClientSecret(ingress, rp); err != nil || rp.ClientSecret != clientSecret {
+		t.Errorf("Expected client secret %s, got %s, %v", clientSecret, rp.ClientSecret, err)
+	}
+
+	// The Secret of another Ingress is not adopted, the Relying Party is
+	// retried on resyncs
+	other := ingress.DeepCopy()
+	other.Name = "test-oidc2"
+	other.UID = "5678"
+	other.Annotations = map[string]string{
+		"kubernetes-controller.lemonldap-ng.org/oidc-rp": `{redirectPaths: [/cb], secretName: test-oidc-oidc-client}`,
+	}
+	ingressController.ingressAdded(other)
+	otherRP := &llngconfig.OIDCRelyingParty{Name: "test-ns_test-oidc2", Owner: "test-ns/test-oidc2"}
+	if ingressController.llngConfig.HasOIDCRelyingParty(otherRP) {
+		t.Errorf("Expected the Relying Party of test-ns/test-oidc2 to be ignored")
+	}
+	secret, err = controllerConfig.Client.CoreV1().Secrets("test-ns").Get("test-oidc-oidc-client", metav1.GetOptions{})
+	if err != nil || string(secret.Data["client-id"]) != "test-ns_test-oidc" {
+		t.Errorf("Expected the Secret of test-ns/test-oidc to be unchanged, got %v, %v", secret, err)
+	}
+	controllerConfig.Client.CoreV1().Secrets("test-ns").Delete("test-oidc-oidc-client", &metav1.DeleteOptions{})
+	ingressController.ingressUpdated(other, other)
+	if !ingressController.llngConfig.HasOIDCRelyingParty(otherRP) {
+		t.Errorf("Expected the Relying Party of test-ns/test-oidc2 to be added on resync")
+	}
+}
+
+func TestSAMLServiceProvider(t *testing.T) {
+	controllerConfig := buildControllerConfig(corev1.NamespaceAll, false)
+	ingressController := NewLemonLDAPNGController(controllerConfig)
+	ingressController.configMapCacheStore.Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "saml",
+			Namespace: "test-ns",
+		},
+		Data: map[string]string{"metadata.xml": `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://test6.example.org/saml"><md:SPSSODescriptor/></md:EntityDescriptor>`},
+	})
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-saml",
+			Namespace: "test-ns",
+			Annotations: map[string]string{
+				"kubernetes-controller.lemonldap-ng.org/saml-sp": `{metadata: saml/metadata.xml, attributes: [{attribute: mail, mandatory: true}], options: {nameIDFormat: email}}`,
+			},
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			Rules: []extensionsv1beta1.IngressRule{
+				{
+					Host: "test6.example.org",
+					IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+						HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+					},
+				},
+			},
+		},
+	}
+	ingressController.ingressCacheStore.Add(ingress)
+	ingressController.ingressAdded(ingress)
+	checkLLConfig(t, ingressController, 2, []*regexp.Regexp{
+		regexp.MustCompile(`"samlSPMetaDataXML": {\s*"test-ns_test-saml": {\s*"samlSPMetaDataXML": ".*entityID=\\"https://test6.example.org/saml\\"`),
+		regexp.MustCompile(`"samlSPMetaDataExportedAttributes": {\s*"test-ns_test-saml": {\s*"mail": "1;mail;;"\s*}\s*}`),
+		regexp.MustCompile(`"samlSPMetaDataOptionsNameIDFormat": "email"`),
+	})
+
+	// A resync doesn't change the configuration, nor the update of an
+	// Ingress with a similar name and without saml-sp
+	ingressController.ingressUpdated(ingress, ingress)
+	similar := ingress.DeepCopy()
+	similar.Namespace = "test-ns-test"
+	similar.Name = "saml"
+	similar.Annotations = nil
+	similar.Spec.Rules[0].Host = "test8.example.org"
+	ingressController.ingressUpdated(similar, similar)
+	if lastConfigName, _, _ := ingressController.llngConfig.Last(); lastConfigName != "lmConf-2.js" {
+		t.Errorf("Expected lmConf-2.js, got %s", lastConfigName)
+	}
+
+	// The Service Provider is refreshed when its metadata changes, and kept
+	// when it can't be read
+	metadata := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "saml",
+			Namespace: "test-ns",
+		},
+		Data: map[string]string{"metadata.xml": `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://test6.example.org/saml2"><md:SPSSODescriptor/></md:EntityDescriptor>`},
+	}
+	oldMetadata, _, _ := ingressController.configMapCacheStore.GetByKey("test-ns/saml")
+	ingressController.configMapCacheStore.Update(metadata)
+	ingressController.configMapUpdated(oldMetadata, metadata)
+	checkLLConfig(t, ingressController, 3, []*regexp.Regexp{
+		regexp.MustCompile(`"test-ns_test-saml": {\s*"samlSPMetaDataXML": ".*entityID=\\"https://test6.example.org/saml2\\"`),
+	})
+	ingressController.configMapCacheStore.Delete(metadata)
+	ingressController.configMapDeleted(metadata)
+	ingressController.ingressUpdated(ingress, ingress)
+	if lastConfigName, _, _ := ingressController.llngConfig.Last(); lastConfigName != "lmConf-3.js" {
+		t.Errorf("Expected the Service Provider to be kept in lmConf-3.js, got %s", lastConfigName)
+	}
+
+	// The Service Provider is removed with the saml-sp annotation
+	withoutSAML := ingress.DeepCopy()
+	withoutSAML.Annotations = nil
+	ingressController.ingressUpdated(ingress, withoutSAML)
+	lmConf, err := controllerConfig.FS.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-4.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if strings.Contains(string(lmConf), "test-ns_test-saml") {
+		t.Errorf("Expected no test-ns_test-saml Service Provider in\n%s", lmConf)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+)
+
+// defaults returns the default location rules and exported headers of
+// Ingresses in namespace: Namespace annotations first, then the ConfigMap,
+// then the flags, then the built-in defaults
+func (c *LemonLDAPNGController) defaults(namespace string) llngconfig.Defaults {
+	c.defaultsLock.RLock()
+	defer c.defaultsLock.RUnlock()
+	return llngconfig.ResolveDefaults(c.namespaceDefaults[namespace], c.configMapDefaults, c.controllerConfig.Defaults)
+}
+
+// setConfigMapDefaults sets the ConfigMap defaults, updating all Ingresses
+// when they changed
+func (c *LemonLDAPNGController) setConfigMapDefaults(defaults llngconfig.Defaults) {
+	c.defaultsLock.Lock()
+	changed := !reflect.DeepEqual(c.configMapDefaults, defaults)
+	oldDefaults := c.configMapDefaults
+	c.configMapDefaults = defaults
+	c.defaultsLock.Unlock()
+	if changed {
+		c.resyncIngresses(corev1.NamespaceAll, func(namespace string) llngconfig.Defaults {
+			c.defaultsLock.RLock()
+			defer c.defaultsLock.RUnlock()
+			return llngconfig.ResolveDefaults(c.namespaceDefaults[namespace], oldDefaults, c.controllerConfig.Defaults)
+		})
+	}
+}
+
+// setNamespaceDefaults sets the defaults of namespace, updating its Ingresses
+// when they changed
+func (c *LemonLDAPNGController) setNamespaceDefaults(namespace string, defaults llngconfig.Defaults) bool {
+	c.defaultsLock.Lock()
+	changed := !reflect.DeepEqual(c.namespaceDefaults[namespace], defaults)
+	oldDefaults := c.namespaceDefaults[namespace]
+	if defaults.LocationRules == nil && defaults.ExportedHeaders == nil {
+		delete(c.namespaceDefaults, namespace)
+	} else {
+		c.namespaceDefaults[namespace] = defaults
+	}
+	c.defaultsLock.Unlock()
+	if changed {
+		c.resyncIngresses(namespace, func(namespace string) llngconfig.Defaults {
+			c.defaultsLock.RLock()
+			defer c.defaultsLock.RUnlock()
+			return llngconfig.ResolveDefaults(oldDefaults, c.configMapDefaults, c.controllerConfig.Defaults)
+		})
+	}
+	return changed
+}
+
+// resyncIngresses updates the Ingresses of namespace, or all Ingresses, to
+// apply new defaults. oldDefaults returns the defaults of a namespace before
+// the change. handlersLock must be held: the store then has no Ingress whose
+// deletion is not handled yet
+func (c *LemonLDAPNGController) resyncIngresses(namespace string, oldDefaults func(namespace string) llngconfig.Defaults) {
+	for _, obj := range c.ingressCacheStore.List() {
+		ingressObj := obj.(*extensionsv1beta1.Ingress)
+		if namespace != corev1.NamespaceAll && ingressObj.Namespace != namespace {
+			continue
+		}
+		c.updateIngress(obj, obj, oldDefaults(ingressObj.Namespace))
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultReconcileTimeout is the default duration after which a running
+// event handler is considered stuck
+const DefaultReconcileTimeout = 5 * time.Minute
+
+// trackReconcile records the start of an event handler. The returned function
+// must be called when the handler returns
+func (c *LemonLDAPNGController) trackReconcile() func() {
+	c.reconcileLock.Lock()
+	id := c.nextReconcileID
+	c.nextReconcileID++
+	c.reconciles[id] = time.Now()
+	c.reconcileLock.Unlock()
+	return func() {
+		c.reconcileLock.Lock()
+		delete(c.reconciles, id)
+		c.reconcileLock.Unlock()
+	}
+}
+
+// oldestReconcile returns the start time of the oldest running event
+// handler, if any
+func (c *LemonLDAPNGController) oldestReconcile() time.Time {
+	c.reconcileLock.RLock()
+	defer c.reconcileLock.RUnlock()
+	oldest := time.Time{}
+	for _, started := range c.reconciles {
+		if oldest.IsZero() || started.Before(oldest) {
+			oldest = started
+		}
+	}
+	return oldest
+}
+
+// livenessChecks returns the failed liveness checks
+func (c *LemonLDAPNGController) livenessChecks() []string {
+	failed := []string{}
+	timeout := c.controllerConfig.ReconcileTimeout
+	if timeout <= 0 {
+		timeout = DefaultReconcileTimeout
+	}
+	started := c.oldestReconcile()
+	if !started.IsZero() && time.Since(started) > timeout {
+		failed = append(failed, fmt.Sprintf("reconcile: running for %s", time.Since(started)))
+	}
+	return failed
+}
+
+// informersSynced returns true when the informer caches are synced
+func (c *LemonLDAPNGController) informersSynced() bool {
+	return c.ingressCacheController.HasSynced() && c.configMapCacheController.HasSynced() && c.namespaceCacheController.HasSynced()
+}
+
+// readinessChecks returns the failed readiness checks
+func (c *LemonLDAPNGController) readinessChecks() []string {
+	failed := []string{}
+	if !c.informersSynced() {
+		failed = append(failed, "informers: not synced")
+	} else if !c.llngConfig.Published() {
+		failed = append(failed, "config: not published")
+	}
+	if !c.supervisor.Status().Running {
+		failed = append(failed, "process: not running")
+	}
+	if err := c.llngConfig.LastReloadError(); err != nil {
+		failed = append(failed, fmt.Sprintf("reload: %s", err))
+	}
+	return failed
+}
+
+// writeChecks writes the result of checks as an HTTP response
+func writeChecks(w http.ResponseWriter, failed []string, failedStatus int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(failed) == 0 {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "ok\n")
+		return
+	}
+	w.WriteHeader(failedStatus)
+	for _, check := range failed {
+		fmt.Fprintf(w, "%s\n", check)
+	}
+}
+
+func (c *LemonLDAPNGController) healthz(w http.ResponseWriter, r *http.Request) {
+	writeChecks(w, c.livenessChecks(), http.StatusInternalServerError)
+}
+
+func (c *LemonLDAPNGController) readyz(w http.ResponseWriter, r *http.Request) {
+	writeChecks(w, c.readinessChecks(), http.StatusServiceUnavailable)
+}
+
+// serveHTTP serves the health and metrics endpoints until stopCh is closed
+func (c *LemonLDAPNGController) serveHTTP(stopCh <-chan struct{}) {
+	if c.controllerConfig.HealthzPort == 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", c.healthz)
+	mux.HandleFunc("/readyz", c.readyz)
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", c.controllerConfig.HealthzPort),
+		Handler: mux,
+	}
+	go func() {
+		<-stopCh
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+	glog.Infof("Serving health and metrics endpoints on %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		glog.Errorf("Unable to serve health and metrics endpoints: %s", err)
+	}
+}
@@ -19,75 +19,247 @@ package controller
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/golang/glog"
-	"gopkg.in/yaml.v2"
 
+	corev1 "k8s.io/api/core/v1"
 	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/metrics"
 )
 
-// parseIngress returns the ingress namespace, the ingress name, and a map of VHosts
-func (c *LemonLDAPNGController) parseIngress(obj interface{}) (string, string, map[string]*llngconfig.VHost, *llngconfig.Application, error) {
+// ingressConfigName returns the LemonLDAP::NG name of the objects of an
+// Ingress: <namespace>_<name>. Kubernetes names can't contain _, so names of
+// different Ingresses never collide
+func ingressConfigName(ingressObj *extensionsv1beta1.Ingress) string {
+	return ingressObj.Namespace + "_" + ingressObj.Name
+}
+
+// parseIngress returns the ingress namespace, the ingress name, a map of VHosts
+// and the applications
+func (c *LemonLDAPNGController) parseIngress(obj interface{}) (string, string, map[string]*llngconfig.VHost, []*llngconfig.Application, error) {
+	return c.parseIngressDefaults(obj, c.defaults(obj.(*extensionsv1beta1.Ingress).Namespace))
+}
+
+// parseIngressDefaults parses an Ingress with the defaults of its namespace
+func (c *LemonLDAPNGController) parseIngressDefaults(obj interface{}, defaults llngconfig.Defaults) (string, string, map[string]*llngconfig.VHost, []*llngconfig.Application, error) {
 	ingressObj := obj.(*extensionsv1beta1.Ingress)
 	ingressNamespace := ingressObj.Namespace
 	ingressName := ingressObj.Name
-	ingressAnnotations := ingressObj.GetAnnotations()
+	prefix := c.controllerConfig.AnnotationsPrefix
+	ingressAnnotations := c.normalizeAnnotations("Ingress", ingressNamespace+"/"+ingressName, ingressObj.GetAnnotations())
 	vhosts := make(map[string]*llngconfig.VHost)
 
-	locationRulesAnnotation := "kubernetes-controller.lemonldap-ng.org/location-rules"
-	locationRules := make(map[string]string)
+	enabled := !c.controllerConfig.OptIn
+	if value, ok := ingressAnnotations[prefix+"/enabled"]; ok {
+		var err error
+		enabled, err = strconv.ParseBool(value)
+		if err != nil {
+			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Invalid enabled %q of Ingress %s/%s, ignoring Ingress: should be true or false", value, ingressNamespace, ingressName)
+		}
+	}
+	if !enabled {
+		return ingressNamespace, ingressName, vhosts, nil, nil
+	}
+
+	locationRulesAnnotation := prefix + "/location-rules"
+	var locationRules map[string]string
 	locationRulesYaml, ok := ingressAnnotations[locationRulesAnnotation]
 	if ok {
-		err := yaml.Unmarshal([]byte(locationRulesYaml), &locationRules)
+		var err error
+		locationRules, err = llngconfig.ParseLocationRules(locationRulesYaml)
 		if err != nil {
 			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Unable to parse locationRules annotation %s of Ingress %s/%s, ignoring Ingress: %s", locationRulesAnnotation, ingressNamespace, ingressName, err)
 		}
-	} else {
-		locationRules = llngconfig.DefaultLocationRules
+	}
+	accessRulesAnnotation := prefix + "/access-rules"
+	accessRulesYaml, ok := ingressAnnotations[accessRulesAnnotation]
+	if ok {
+		if locationRules != nil {
+			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Both %s and %s annotations are set on Ingress %s/%s, ignoring Ingress", locationRulesAnnotation, accessRulesAnnotation, ingressNamespace, ingressName)
+		}
+		var err error
+		locationRules, err = llngconfig.ParseAccessRules(accessRulesYaml)
+		if err != nil {
+			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Unable to parse accessRules annotation %s of Ingress %s/%s, ignoring Ingress: %s", accessRulesAnnotation, ingressNamespace, ingressName, err)
+		}
+	}
+	if locationRules == nil {
+		locationRules = defaults.LocationRules
+	}
+
+	formReplayAnnotation := prefix + "/form-replay"
+	var formReplays []llngconfig.FormReplay
+	if formReplayYaml, ok := ingressAnnotations[formReplayAnnotation]; ok {
+		var err error
+		formReplays, err = llngconfig.ParseFormReplays(formReplayYaml)
+		if err != nil {
+			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Unable to parse formReplay annotation %s of Ingress %s/%s, ignoring Ingress: %s", formReplayAnnotation, ingressNamespace, ingressName, err)
+		}
 	}
 
-	exportedHeadersAnnotation := "kubernetes-controller.lemonldap-ng.org/exported-headers"
-	exportedHeaders := make(map[string]string)
+	exportedHeadersAnnotation := prefix + "/exported-headers"
+	var exportedHeaders map[string]string
 	exportedHeadersYaml, ok := ingressAnnotations[exportedHeadersAnnotation]
 	if ok {
-		err := yaml.Unmarshal([]byte(exportedHeadersYaml), &exportedHeaders)
+		var err error
+		exportedHeaders, err = llngconfig.ParseExportedHeaders(exportedHeadersYaml)
 		if err != nil {
 			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Unable to parse exportedHeaders annotation %s of Ingress %s/%s, ignoring Ingress: %s", exportedHeadersAnnotation, ingressNamespace, ingressName, err)
 		}
 	} else {
-		exportedHeaders = llngconfig.DefaultExportedHeaders
+		exportedHeaders = defaults.ExportedHeaders
+	}
+	exportedHeaders, err := llngconfig.CompileExportedHeaders(exportedHeaders, c.controllerConfig.DeniedHeaderAttributes)
+	if err != nil {
+		return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Invalid exported headers of Ingress %s/%s, ignoring Ingress: %s", ingressNamespace, ingressName, err)
+	}
+
+	// Scoped rules only apply to the Ingress paths. extensions/v1beta1 paths
+	// have no pathType, it is given by annotation
+	scopedRules := false
+	if value, ok := ingressAnnotations[prefix+"/scoped-rules"]; ok {
+		var err error
+		scopedRules, err = strconv.ParseBool(value)
+		if err != nil {
+			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Invalid scoped-rules %q of Ingress %s/%s, ignoring Ingress: should be true or false", value, ingressNamespace, ingressName)
+		}
+	}
+	pathType := ingressAnnotations[prefix+"/path-type"]
+
+	// TLS hosts, with the referenced Secret
+	tlsSecrets := make(map[string]string)
+	for _, tls := range ingressObj.Spec.TLS {
+		hosts := tls.Hosts
+		if len(hosts) == 0 {
+			// A TLS section without hosts applies to all the hosts of the
+			// Ingress
+			hosts = []string{llngconfig.DefaultServerName}
+			for _, rule := range ingressObj.Spec.Rules {
+				hosts = append(hosts, rule.Host)
+			}
+		}
+		for _, host := range hosts {
+			serverName, err := llngconfig.NormalizeServerName(host)
+			if err != nil {
+				return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Invalid TLS host of Ingress %s/%s, ignoring Ingress: %s", ingressNamespace, ingressName, err)
+			}
+			tlsSecrets[serverName] = ""
+			if tls.SecretName != "" {
+				tlsSecrets[serverName] = ingressNamespace + "/" + tls.SecretName
+			}
+		}
 	}
 
 	var firstVHost *llngconfig.VHost
 	for _, rule := range ingressObj.Spec.Rules {
-		serverName := rule.Host
-		if serverName == "" || serverName == "*" {
-			serverName = "default"
+		serverName, err := llngconfig.NormalizeServerName(rule.Host)
+		if err != nil {
+			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Invalid host of Ingress %s/%s, ignoring Ingress: %s", ingressNamespace, ingressName, err)
 		}
 		if rule.HTTP == nil {
 			continue
 		}
-		vhosts[serverName] = llngconfig.NewVHost(serverName, locationRules, exportedHeaders)
-		if firstVHost == nil {
+		if serverName == llngconfig.DefaultServerName && c.controllerConfig.DefaultVHostPolicy == llngconfig.DefaultVHostDeny {
+			glog.Warningf("Ignoring host-less rule of Ingress %s/%s, denied by the default vhost policy", ingressNamespace, ingressName)
+			continue
+		}
+		tlsSecret, tls := llngconfig.MatchHost(tlsSecrets, serverName)
+		vhostOptions, err := llngconfig.NewVHostOptions(ingressAnnotations, prefix, tls)
+		if err != nil {
+			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Unable to parse vhost options annotations of Ingress %s/%s, ignoring Ingress: %s", ingressNamespace, ingressName, err)
+		}
+		ingressPaths := []string{}
+		scopePaths := []llngconfig.IngressPath{}
+		for _, path := range rule.HTTP.Paths {
+			if path.Path == "" {
+				ingressPaths = append(ingressPaths, "/")
+			} else {
+				ingressPaths = append(ingressPaths, path.Path)
+			}
+			scopePaths = append(scopePaths, llngconfig.IngressPath{
+				Path:     path.Path,
+				PathType: pathType,
+			})
+		}
+		vhostLocationRules := locationRules
+		if scopedRules {
+			vhostLocationRules, err = llngconfig.ScopeLocationRules(locationRules, scopePaths)
+			if err != nil {
+				return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Unable to scope location rules of Ingress %s/%s, ignoring Ingress: %s", ingressNamespace, ingressName, err)
+			}
+		}
+		publicPathsRules, err := llngconfig.PublicPathsLocationRules(ingressAnnotations, prefix, ingressPaths)
+		if err != nil {
+			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Unable to parse public-paths annotations of Ingress %s/%s, ignoring Ingress: %s", ingressNamespace, ingressName, err)
+		}
+		vhostLocationRules, err = llngconfig.MergeLocationRules(vhostLocationRules, publicPathsRules)
+		if err != nil {
+			return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Unable to add public-paths of Ingress %s/%s, ignoring Ingress: %s", ingressNamespace, ingressName, err)
+		}
+		vhosts[serverName] = llngconfig.NewVHost(serverName, vhostLocationRules, exportedHeaders)
+		vhosts[serverName].Owner = ingressNamespace + "/" + ingressName
+		vhosts[serverName].Options = vhostOptions
+		vhosts[serverName].TLS = tls
+		vhosts[serverName].TLSSecret = tlsSecret
+		vhosts[serverName].FormReplays = formReplays
+		vhosts[serverName].Shared = scopedRules
+		// Wildcard hosts have no URL, prefer another host for the application
+		if firstVHost == nil || (llngconfig.IsWildcard(firstVHost.ServerName) && !llngconfig.IsWildcard(serverName)) {
 			firstVHost = vhosts[serverName]
 		}
 	}
 
-	application := llngconfig.NewApplication(firstVHost, ingressAnnotations, "kubernetes-controller.lemonldap-ng.org")
-	return ingressNamespace, ingressName, vhosts, application, nil
+	if firstVHost != nil && llngconfig.IsWildcard(firstVHost.ServerName) {
+		if _, ok := ingressAnnotations[prefix+"/application-uri"]; !ok {
+			firstVHost = nil
+		}
+	}
+	applications, err := llngconfig.NewApplications(vhosts, firstVHost, ingressAnnotations, prefix)
+	if err != nil {
+		return ingressNamespace, ingressName, vhosts, nil, fmt.Errorf("Unable to parse applications of Ingress %s/%s, ignoring Ingress: %s", ingressNamespace, ingressName, err)
+	}
+	for _, application := range applications {
+		application.Owner = ingressNamespace + "/" + ingressName
+	}
+	return ingressNamespace, ingressName, vhosts, applications, nil
 }
 
 func (c *LemonLDAPNGController) ingressAdded(obj interface{}) {
-	ingressNamespace, ingressName, vhosts, application, err := c.parseIngress(obj)
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	ingressNamespace, ingressName, vhosts, applications, err := c.parseIngress(obj)
 	if err != nil {
+		metrics.AnnotationParseErrors.WithLabelValues(ingressNamespace).Inc()
 		glog.Error(err)
 		return
 	}
+	if len(vhosts) == 0 && len(applications) == 0 {
+		glog.V(2).Infof("Ignoring ingress %s/%s", ingressNamespace, ingressName)
+		return
+	}
+	rp, err := c.parseOIDCRelyingParty(obj, vhosts)
+	if err != nil {
+		metrics.AnnotationParseErrors.WithLabelValues(ingressNamespace).Inc()
+		glog.Error(err)
+	}
+	sp, err := c.parseSAMLServiceProvider(obj, vhosts)
+	if err != nil {
+		metrics.AnnotationParseErrors.WithLabelValues(ingressNamespace).Inc()
+		glog.Error(err)
+	}
 	glog.Infof("An ingress was created: %s/%s", ingressNamespace, ingressName)
+	c.checkTLSSecrets(obj.(*extensionsv1beta1.Ingress), vhosts)
+	c.resolveLogos(obj.(*extensionsv1beta1.Ingress), applications)
 	c.llngConfig.AddVHosts(vhosts)
-	c.llngConfig.AddApplication(application)
+	c.llngConfig.AddApplications(applications)
+	c.addOIDCRelyingParty(obj, rp)
+	c.llngConfig.AddSAMLServiceProvider(sp)
 	err = c.llngConfig.Save() // FIXME async + batch
 	if err != nil {
 		glog.Error(err)
@@ -96,14 +268,24 @@ func (c *LemonLDAPNGController) ingressAdded(obj interface{}) {
 }
 
 func (c *LemonLDAPNGController) ingressDeleted(obj interface{}) {
-	ingressNamespace, ingressName, vhosts, application, err := c.parseIngress(obj)
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	ingressNamespace, ingressName, vhosts, applications, err := c.parseIngress(obj)
+	c.forgetDeprecations("Ingress", ingressNamespace+"/"+ingressName)
 	if err != nil {
 		glog.Error(err)
 		return
 	}
+	if len(vhosts) == 0 && len(applications) == 0 {
+		return
+	}
+	rp, _ := c.parseOIDCRelyingParty(obj, vhosts)
 	glog.Infof("An ingress was deleted: %s/%s", ingressNamespace, ingressName)
 	c.llngConfig.DeleteVHosts(vhosts)
-	c.llngConfig.DeleteApplication(application)
+	c.llngConfig.DeleteApplications(applications)
+	c.llngConfig.DeleteOIDCRelyingParty(rp)
+	c.llngConfig.DeleteSAMLServiceProvider(ingressConfigName(obj.(*extensionsv1beta1.Ingress)), ingressNamespace+"/"+ingressName)
 	err = c.llngConfig.Save() // FIXME async + batch
 	if err != nil {
 		glog.Error(err)
@@ -112,29 +294,97 @@ func (c *LemonLDAPNGController) ingressDeleted(obj interface{}) {
 }
 
 func (c *LemonLDAPNGController) ingressUpdated(old, cur interface{}) {
-	_, _, oldVHosts, oldApplication, err := c.parseIngress(old)
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	c.updateIngress(old, cur, c.defaults(old.(*extensionsv1beta1.Ingress).Namespace))
+	err := c.llngConfig.Save() // FIXME async + batch
 	if err != nil {
 		glog.Error(err)
 		return
 	}
-	curIngressNamespace, curIngressName, curVHosts, curApplication, err := c.parseIngress(cur)
+}
+
+// updateIngress replaces the configuration of the old Ingress, parsed with
+// oldDefaults, by the one of the current Ingress. When the current Ingress
+// can't be parsed, the previous configuration is kept. handlersLock must be
+// held
+func (c *LemonLDAPNGController) updateIngress(old, cur interface{}, oldDefaults llngconfig.Defaults) {
+	_, _, oldVHosts, oldApplications, err := c.parseIngressDefaults(old, oldDefaults)
+	if err != nil {
+		// Already reported, the old Ingress was ignored
+		oldVHosts, oldApplications = map[string]*llngconfig.VHost{}, nil
+	}
+	curIngressNamespace, curIngressName, curVHosts, curApplications, err := c.parseIngress(cur)
 	if err != nil {
+		metrics.AnnotationParseErrors.WithLabelValues(curIngressNamespace).Inc()
 		glog.Error(err)
 		return
 	}
+	if len(oldVHosts) == 0 && len(oldApplications) == 0 && len(curVHosts) == 0 && len(curApplications) == 0 {
+		return
+	}
+	c.checkTLSSecrets(cur.(*extensionsv1beta1.Ingress), curVHosts)
 	if !reflect.DeepEqual(oldVHosts, curVHosts) {
 		glog.Infof("An ingress was updated (vhosts): %s/%s", curIngressNamespace, curIngressName)
 		c.llngConfig.DeleteVHosts(oldVHosts)
 		c.llngConfig.AddVHosts(curVHosts)
 	}
-	if !reflect.DeepEqual(oldApplication, curApplication) {
-		glog.Infof("An ingress was updated (application): %s/%s", curIngressNamespace, curIngressName)
-		c.llngConfig.DeleteApplication(oldApplication)
-		c.llngConfig.AddApplication(curApplication)
+	if !reflect.DeepEqual(oldApplications, curApplications) {
+		glog.Infof("An ingress was updated (applications): %s/%s", curIngressNamespace, curIngressName)
+		c.llngConfig.DeleteApplications(oldApplications)
 	}
-	err = c.llngConfig.Save() // FIXME async + batch
+	// Logos are read from ConfigMaps and backends: resolve them on each
+	// update, including resyncs. Unchanged applications are kept as is
+	c.resolveLogos(cur.(*extensionsv1beta1.Ingress), curApplications)
+	c.llngConfig.AddApplications(curApplications)
+	oldRP, _ := c.parseOIDCRelyingParty(old, oldVHosts)
+	curRP, err := c.parseOIDCRelyingParty(cur, curVHosts)
+	if err != nil {
+		metrics.AnnotationParseErrors.WithLabelValues(curIngressNamespace).Inc()
+		glog.Error(err)
+	}
+	// A Relying Party whose client secret could not be set is retried on
+	// each update, including resyncs
+	if !reflect.DeepEqual(oldRP, curRP) || (curRP != nil && !c.llngConfig.HasOIDCRelyingParty(curRP)) {
+		glog.Infof("An ingress was updated (OIDC Relying Party): %s/%s", curIngressNamespace, curIngressName)
+		c.llngConfig.DeleteOIDCRelyingParty(oldRP)
+		c.addOIDCRelyingParty(cur, curRP)
+	}
+	oldSP, _ := c.parseSAMLServiceProvider(old, oldVHosts)
+	curSP, err := c.parseSAMLServiceProvider(cur, curVHosts)
 	if err != nil {
+		// The last valid Service Provider is kept, e.g. when its metadata
+		// can't be read
+		metrics.AnnotationParseErrors.WithLabelValues(curIngressNamespace).Inc()
 		glog.Error(err)
 		return
 	}
+	if !reflect.DeepEqual(oldSP, curSP) {
+		glog.Infof("An ingress was updated (SAML Service Provider): %s/%s", curIngressNamespace, curIngressName)
+	}
+	// The metadata is read from a ConfigMap: refresh the Service Provider on
+	// each update, including resyncs and ConfigMap changes. Only the one of
+	// this Ingress is deleted
+	if curSP == nil {
+		c.llngConfig.DeleteSAMLServiceProvider(ingressConfigName(cur.(*extensionsv1beta1.Ingress)), curIngressNamespace+"/"+curIngressName)
+	} else {
+		c.llngConfig.AddSAMLServiceProvider(curSP)
+	}
+}
+
+// conflictDetected reports a configuration conflict as an Event of the
+// Ingress or ConfigMap owner
+func (c *LemonLDAPNGController) conflictDetected(conflict llngconfig.Conflict) {
+	store := c.ingressCacheStore
+	key := conflict.Owner
+	if strings.HasPrefix(key, llngconfig.ConfigMapOwnerPrefix) {
+		store = c.configMapCacheStore
+		key = strings.TrimPrefix(key, llngconfig.ConfigMapOwnerPrefix)
+	}
+	obj, exists, err := store.GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+	c.recorder.Event(obj.(runtime.Object), corev1.EventTypeWarning, conflict.Reason, conflict.Message)
 }
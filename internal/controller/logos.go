@@ -0,0 +1,109 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+)
+
+// resolveLogos writes the custom logos of the Ingress applications, falling
+// back to the default logo
+func (c *LemonLDAPNGController) resolveLogos(ingressObj *extensionsv1beta1.Ingress, applications []*llngconfig.Application) {
+	for _, application := range applications {
+		logo, err := c.resolveLogo(ingressObj, application.Logo)
+		if err != nil {
+			glog.Warningf("Unable to set logo of application %s of Ingress %s/%s, using %s: %s", application.Path(), ingressObj.Namespace, ingressObj.Name, llngconfig.DefaultLogo, err)
+			logo = llngconfig.DefaultLogo
+		}
+		application.Logo = logo
+	}
+}
+
+// logoFetched updates the Ingresses of a backend when its favicon is fetched
+// for the first time
+func (c *LemonLDAPNGController) logoFetched(url string) {
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	for _, obj := range c.ingressCacheStore.List() {
+		ingressObj := obj.(*extensionsv1beta1.Ingress)
+		if backend, err := backendURL(ingressObj); err != nil || backend+"/favicon.ico" != url {
+			continue
+		}
+		c.updateIngress(obj, obj, c.defaults(ingressObj.Namespace))
+	}
+	err := c.llngConfig.Save() // FIXME async + batch
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+}
+
+// resolveLogo returns the file name of a logo, writing custom logos
+func (c *LemonLDAPNGController) resolveLogo(ingressObj *extensionsv1beta1.Ingress, logo string) (string, error) {
+	if !strings.HasPrefix(logo, llngconfig.LogoConfigMapPrefix) && logo != llngconfig.LogoFavicon {
+		return logo, nil
+	}
+	if c.logos == nil {
+		return "", fmt.Errorf("custom logos require --logos-directory")
+	}
+	if logo == llngconfig.LogoFavicon {
+		url, err := backendURL(ingressObj)
+		if err != nil {
+			return "", err
+		}
+		return c.logos.Fetch(ingressObj.Namespace+"_"+ingressObj.Name+"_favicon.ico", url+"/favicon.ico")
+	}
+	ref := strings.SplitN(strings.TrimPrefix(logo, llngconfig.LogoConfigMapPrefix), "/", 2)
+	if len(ref) != 2 || ref[0] == "" || ref[1] == "" {
+		return "", fmt.Errorf("Invalid logo %q: should be %s<name>/<key>", logo, llngconfig.LogoConfigMapPrefix)
+	}
+	content, err := c.configMapKey(ingressObj.Namespace, ref[0], ref[1])
+	if err != nil {
+		return "", err
+	}
+	return c.logos.Write(ingressObj.Namespace+"_"+ref[0]+"_"+ref[1], content)
+}
+
+// backendURL returns the URL of the default backend Service of the Ingress,
+// or of its first path
+func backendURL(ingressObj *extensionsv1beta1.Ingress) (string, error) {
+	backend := ingressObj.Spec.Backend
+	for _, rule := range ingressObj.Spec.Rules {
+		if backend != nil {
+			break
+		}
+		if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 {
+			backend = &rule.HTTP.Paths[0].Backend
+		}
+	}
+	if backend == nil {
+		return "", fmt.Errorf("Ingress %s/%s has no backend", ingressObj.Namespace, ingressObj.Name)
+	}
+	if backend.ServicePort.Type != intstr.Int {
+		return "", fmt.Errorf("named port %s of Service %s is not supported", backend.ServicePort.StrVal, backend.ServiceName)
+	}
+	return fmt.Sprintf("http://%s.%s.svc:%d", backend.ServiceName, ingressObj.Namespace, backend.ServicePort.IntVal), nil
+}
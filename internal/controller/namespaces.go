@@ -0,0 +1,91 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+)
+
+// parseNamespace returns the namespace name and its defaults
+func (c *LemonLDAPNGController) parseNamespace(obj interface{}) (string, llngconfig.Defaults, error) {
+	namespaceObj := obj.(*corev1.Namespace)
+	prefix := c.controllerConfig.AnnotationsPrefix
+	annotations := c.normalizeAnnotations("Namespace", namespaceObj.Name, namespaceObj.GetAnnotations())
+	defaults, err := llngconfig.NewDefaults(
+		annotations[prefix+"/default-location-rules"],
+		annotations[prefix+"/default-exported-headers"],
+	)
+	if err != nil {
+		return namespaceObj.Name, llngconfig.Defaults{}, fmt.Errorf("Unable to parse annotations of Namespace %s, keeping the previous defaults: %s", namespaceObj.Name, err)
+	}
+	return namespaceObj.Name, defaults, nil
+}
+
+func (c *LemonLDAPNGController) namespaceAdded(obj interface{}) {
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	namespace, defaults, err := c.parseNamespace(obj)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	c.applyNamespaceDefaults(namespace, defaults, "added")
+}
+
+func (c *LemonLDAPNGController) namespaceDeleted(obj interface{}) {
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	namespace, _, _ := c.parseNamespace(obj)
+	c.forgetDeprecations("Namespace", namespace)
+	c.applyNamespaceDefaults(namespace, llngconfig.Defaults{}, "deleted")
+}
+
+func (c *LemonLDAPNGController) namespaceUpdated(old, cur interface{}) {
+	defer c.trackReconcile()()
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	// Invalid defaults would fall back to less restrictive ones: the
+	// previous ones are kept
+	namespace, defaults, err := c.parseNamespace(cur)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	c.applyNamespaceDefaults(namespace, defaults, "updated")
+}
+
+// applyNamespaceDefaults saves the configuration when the namespace defaults
+// changed
+func (c *LemonLDAPNGController) applyNamespaceDefaults(namespace string, defaults llngconfig.Defaults, event string) {
+	if !c.setNamespaceDefaults(namespace, defaults) {
+		return
+	}
+	glog.Infof("A namespace with defaults was %s: %s", event, namespace)
+	err := c.llngConfig.Save() // FIXME async + batch
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+}
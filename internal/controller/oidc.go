@@ -0,0 +1,141 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+)
+
+// Keys of the OIDC client Secret
+const (
+	oidcClientIDKey     = "client-id"
+	oidcClientSecretKey = "client-secret"
+)
+
+// parseOIDCRelyingParty returns the OIDC Relying Party of the oidc-rp
+// annotation of the Ingress, if any
+func (c *LemonLDAPNGController) parseOIDCRelyingParty(obj interface{}, vhosts map[string]*llngconfig.VHost) (*llngconfig.OIDCRelyingParty, error) {
+	ingressObj := obj.(*extensionsv1beta1.Ingress)
+	prefix := c.controllerConfig.AnnotationsPrefix
+	ingressAnnotations := c.normalizeAnnotations("Ingress", ingressObj.Namespace+"/"+ingressObj.Name, ingressObj.GetAnnotations())
+	in, ok := ingressAnnotations[prefix+"/oidc-rp"]
+	if !ok || len(vhosts) == 0 {
+		return nil, nil
+	}
+	rp, err := llngconfig.NewOIDCRelyingParty(ingressConfigName(ingressObj), in, vhosts)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse oidc-rp annotation of Ingress %s/%s, ignoring Relying Party: %s", ingressObj.Namespace, ingressObj.Name, err)
+	}
+	if rp.SecretName == "" {
+		rp.SecretName = ingressObj.Name + "-oidc-client"
+	}
+	rp.Owner = ingressObj.Namespace + "/" + ingressObj.Name
+	return rp, nil
+}
+
+// addOIDCRelyingParty adds the Relying Party of the Ingress, if any, once its
+// client secret is known
+func (c *LemonLDAPNGController) addOIDCRelyingParty(obj interface{}, rp *llngconfig.OIDCRelyingParty) {
+	if rp == nil {
+		return
+	}
+	if err := c.ensureOIDCClientSecret(obj.(*extensionsv1beta1.Ingress), rp); err != nil {
+		glog.Error(err)
+		return
+	}
+	c.llngConfig.AddOIDCRelyingParty(rp)
+}
+
+// ensureOIDCClientSecret sets the client secret of a confidential Relying
+// Party from its Secret, creating the Secret, owned by the Ingress, if needed.
+// A Secret which is not controlled by the Ingress is reported as a conflict
+func (c *LemonLDAPNGController) ensureOIDCClientSecret(ingressObj *extensionsv1beta1.Ingress, rp *llngconfig.OIDCRelyingParty) error {
+	if rp.Public {
+		return nil
+	}
+	secrets := c.controllerConfig.Client.CoreV1().Secrets(ingressObj.Namespace)
+	secret, err := secrets.Get(rp.SecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		clientSecret, err := generateClientSecret()
+		if err != nil {
+			return err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      rp.SecretName,
+				Namespace: ingressObj.Namespace,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(ingressObj, extensionsv1beta1.SchemeGroupVersion.WithKind("Ingress")),
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				oidcClientIDKey:     []byte(rp.ClientID),
+				oidcClientSecretKey: []byte(clientSecret),
+			},
+		}
+		if _, err = secrets.Create(secret); err != nil {
+			return fmt.Errorf("Unable to create Secret %s/%s: %s", ingressObj.Namespace, rp.SecretName, err)
+		}
+		rp.ClientSecret = clientSecret
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to get Secret %s/%s: %s", ingressObj.Namespace, rp.SecretName, err)
+	}
+	if owner := metav1.GetControllerOf(secret); owner == nil || owner.UID != ingressObj.UID {
+		conflict := llngconfig.Conflict{
+			Owner:   rp.Owner,
+			Reason:  llngconfig.ReasonOIDCRelyingPartyConflict,
+			Message: fmt.Sprintf("Secret %s is not controlled by the Ingress, ignoring OIDC Relying Party %s", rp.SecretName, rp.Name),
+		}
+		c.conflictDetected(conflict)
+		return fmt.Errorf("%s: %s", conflict.Owner, conflict.Message)
+	}
+	clientSecret := string(secret.Data[oidcClientSecretKey])
+	if clientSecret == "" {
+		return fmt.Errorf("Secret %s/%s has no %s key", ingressObj.Namespace, rp.SecretName, oidcClientSecretKey)
+	}
+	if string(secret.Data[oidcClientIDKey]) != rp.ClientID {
+		secret.Data[oidcClientIDKey] = []byte(rp.ClientID)
+		if _, err = secrets.Update(secret); err != nil {
+			return fmt.Errorf("Unable to update Secret %s/%s: %s", ingressObj.Namespace, rp.SecretName, err)
+		}
+	}
+	rp.ClientSecret = clientSecret
+	return nil
+}
+
+// generateClientSecret returns a new random client secret
+func generateClientSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Unable to generate client secret: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -18,34 +18,45 @@ package controller
 
 import (
 	"os"
-	"os/exec"
-	"syscall"
 
-	"github.com/golang/glog"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/logging"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/metrics"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/process"
 )
 
-// StartProcess starts a new LemonLDAP::NG master process running in foreground
-func (c *LemonLDAPNGController) StartProcess(stopCh <-chan struct{}) {
-	cmd := exec.Command(c.controllerConfig.Command[0], c.controllerConfig.Command[1:]...)
-
-	// put llng-fastcgi-server in another process group to prevent it
-	// to receive signals meant for the controller
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-		Pgid:    0,
+// newSupervisor creates the LemonLDAP::NG process supervisor
+func newSupervisor(controllerConfig *Configuration) *process.Supervisor {
+	supervisor := process.NewSupervisor(controllerConfig.Command)
+	supervisor.OnRestart = metrics.ProcessRestarts.Inc
+	format := controllerConfig.LogFormat
+	if format == "" {
+		format = logging.FormatText
 	}
-
-	glog.Info("Starting LemonLDAP::NG process...")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
-		glog.Fatalf("LemonLDAP::NG error: %v", err)
-		c.llngErrCh <- err
-		return
+	supervisor.Stdout = logging.NewLineWriter(logging.NewLogger(os.Stdout, format), logging.ParseLemonLDAPNGLine, "info")
+	supervisor.Stderr = logging.NewLineWriter(logging.NewLogger(logging.Stderr(), format), logging.ParseLemonLDAPNGLine, "warning")
+	if controllerConfig.ProcessMinBackoff > 0 {
+		supervisor.MinBackoff = controllerConfig.ProcessMinBackoff
+	}
+	if controllerConfig.ProcessMaxBackoff > 0 {
+		supervisor.MaxBackoff = controllerConfig.ProcessMaxBackoff
+	}
+	if controllerConfig.ProcessCrashLoopThreshold > 0 {
+		supervisor.CrashLoopThreshold = controllerConfig.ProcessCrashLoopThreshold
 	}
+	if controllerConfig.ProcessGracePeriod > 0 {
+		supervisor.GracePeriod = controllerConfig.ProcessGracePeriod
+	}
+	return supervisor
+}
 
-	go func() {
-		c.llngErrCh <- cmd.Wait()
-	}()
+// StartProcess starts a new LemonLDAP::NG master process running in foreground,
+// and restarts it when it exits. When stopCh is closed, the process group is
+// terminated gracefully. The result is sent to llngErrCh
+func (c *LemonLDAPNGController) StartProcess(stopCh <-chan struct{}) {
+	c.llngErrCh <- c.supervisor.Run(stopCh)
+}
 
+// ProcessStatus returns the LemonLDAP::NG process status
+func (c *LemonLDAPNGController) ProcessStatus() process.Status {
+	return c.supervisor.Status()
 }
@@ -0,0 +1,54 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+)
+
+// parseSAMLServiceProvider returns the SAML Service Provider of the saml-sp
+// annotation of the Ingress, if any, with its metadata from a ConfigMap of the
+// Ingress namespace
+func (c *LemonLDAPNGController) parseSAMLServiceProvider(obj interface{}, vhosts map[string]*llngconfig.VHost) (*llngconfig.SAMLServiceProvider, error) {
+	ingressObj := obj.(*extensionsv1beta1.Ingress)
+	prefix := c.controllerConfig.AnnotationsPrefix
+	ingressAnnotations := c.normalizeAnnotations("Ingress", ingressObj.Namespace+"/"+ingressObj.Name, ingressObj.GetAnnotations())
+	in, ok := ingressAnnotations[prefix+"/saml-sp"]
+	if !ok || len(vhosts) == 0 {
+		return nil, nil
+	}
+	spec, err := llngconfig.ParseSAMLServiceProviderSpec(in)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse saml-sp annotation of Ingress %s/%s, ignoring Service Provider: %s", ingressObj.Namespace, ingressObj.Name, err)
+	}
+	ref := strings.SplitN(spec.Metadata, "/", 2)
+	metadata, err := c.configMapKey(ingressObj.Namespace, ref[0], ref[1])
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get SAML metadata of Ingress %s/%s, ignoring Service Provider: %s", ingressObj.Namespace, ingressObj.Name, err)
+	}
+	sp, err := llngconfig.NewSAMLServiceProvider(ingressConfigName(ingressObj), spec, string(metadata))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse saml-sp annotation of Ingress %s/%s, ignoring Service Provider: %s", ingressObj.Namespace, ingressObj.Name, err)
+	}
+	sp.Owner = ingressObj.Namespace + "/" + ingressObj.Name
+	return sp, nil
+}
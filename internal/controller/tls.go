@@ -0,0 +1,60 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	llngconfig "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/lemonldapng/config"
+)
+
+// ReasonTLSSecretMissing is the reason of the Event of an Ingress referencing
+// a missing TLS Secret
+const ReasonTLSSecretMissing = "TLSSecretMissing"
+
+// checkTLSSecrets reports the TLS Secrets referenced by the Ingress vhosts
+// which don't exist as warning Events of the Ingress
+func (c *LemonLDAPNGController) checkTLSSecrets(ingressObj *extensionsv1beta1.Ingress, vhosts map[string]*llngconfig.VHost) {
+	secretHosts := make(map[string][]string)
+	for serverName, vhost := range vhosts {
+		if vhost.TLSSecret != "" {
+			secretHosts[vhost.TLSSecret] = append(secretHosts[vhost.TLSSecret], serverName)
+		}
+	}
+	for secretKey, serverNames := range secretHosts {
+		ref := strings.SplitN(secretKey, "/", 2)
+		_, err := c.controllerConfig.Client.CoreV1().Secrets(ref[0]).Get(ref[1], metav1.GetOptions{})
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			glog.Errorf("Unable to get TLS Secret %s of Ingress %s/%s: %s", secretKey, ingressObj.Namespace, ingressObj.Name, err)
+			continue
+		}
+		sort.Strings(serverNames)
+		glog.Warningf("TLS Secret %s of Ingress %s/%s is missing (hosts %s)", secretKey, ingressObj.Namespace, ingressObj.Name, strings.Join(serverNames, ", "))
+		c.recorder.Eventf(ingressObj, corev1.EventTypeWarning, ReasonTLSSecretMissing, "TLS Secret %s is missing (hosts %s)", ref[1], strings.Join(serverNames, ", "))
+	}
+}
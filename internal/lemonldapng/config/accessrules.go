@@ -0,0 +1,295 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AccessRule defines a structured LemonLDAP::NG access rule, compiled into a
+// rule expression
+type AccessRule struct {
+	// Path is the path regex, or "default"
+	Path    string `yaml:"path"`
+	Comment string `yaml:"comment,omitempty"`
+	// Action is one of accept (default), deny, skip, unprotect, logout,
+	// logout_sso, logout_app or logout_app_sso
+	Action string `yaml:"action,omitempty"`
+
+	// Conditions, all of which must match for accept, or none of which for
+	// deny. Each list matches when any of its values matches
+	Users               []string     `yaml:"users,omitempty"`
+	Groups              []string     `yaml:"groups,omitempty"`
+	IPs                 []string     `yaml:"ips,omitempty"`
+	AuthenticationLevel int          `yaml:"authenticationLevel,omitempty"`
+	TimeWindows         []TimeWindow `yaml:"timeWindows,omitempty"`
+}
+
+// TimeWindow defines a daily time window, in the LemonLDAP::NG server local
+// time
+type TimeWindow struct {
+	// Days are day names (mon, tue, ...) or ranges (mon-fri). Empty means
+	// every day
+	Days []string `yaml:"days,omitempty"`
+	// From and To are HH:MM times. The window spans midnight when From is
+	// after To
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+var (
+	weekDays = map[string]int{
+		"sun": 0,
+		"mon": 1,
+		"tue": 2,
+		"wed": 3,
+		"thu": 4,
+		"fri": 5,
+		"sat": 6,
+	}
+	timeRE = regexp.MustCompile(`^([01]?\d|2[0-3]):([0-5]\d)$`)
+)
+
+// ParseAccessRules parses an ordered list of AccessRule, and returns the
+// compiled location rules
+func ParseAccessRules(in string) (map[string]string, error) {
+	var accessRules []AccessRule
+	if err := yaml.UnmarshalStrict([]byte(in), &accessRules); err != nil {
+		return nil, err
+	}
+	list := make([]LocationRule, len(accessRules))
+	for i, accessRule := range accessRules {
+		rule, err := accessRule.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("Access rule %d (%s): %s", i, accessRule.Path, err)
+		}
+		list[i] = LocationRule{
+			Path:    accessRule.Path,
+			Rule:    rule,
+			Comment: accessRule.Comment,
+		}
+	}
+	return OrderLocationRules(list)
+}
+
+// Compile returns the LemonLDAP::NG rule expression of the access rule
+func (r *AccessRule) Compile() (string, error) {
+	conditions, err := r.conditions()
+	if err != nil {
+		return "", err
+	}
+	switch r.Action {
+	case "", "accept":
+		if len(conditions) == 0 {
+			return "accept", nil
+		}
+		return strings.Join(conditions, " and "), nil
+	case "deny":
+		if len(conditions) == 0 {
+			return "deny", nil
+		}
+		return "not (" + strings.Join(conditions, " and ") + ")", nil
+	case "skip", "unprotect", "logout", "logout_sso", "logout_app", "logout_app_sso":
+		if len(conditions) != 0 {
+			return "", fmt.Errorf("action %s does not accept conditions", r.Action)
+		}
+		if r.Action == "logout" {
+			return "logout_sso", nil
+		}
+		return r.Action, nil
+	}
+	return "", fmt.Errorf("unsupported action %q", r.Action)
+}
+
+// conditions returns the compiled conditions of the access rule
+func (r *AccessRule) conditions() ([]string, error) {
+	conditions := []string{}
+	if len(r.Users) > 0 {
+		users := make([]string, len(r.Users))
+		for i, user := range r.Users {
+			if user == "" {
+				return nil, fmt.Errorf("empty user")
+			}
+			users[i] = "$uid eq " + perlQuote(user)
+		}
+		conditions = append(conditions, anyOf(users))
+	}
+	if len(r.Groups) > 0 {
+		groups := make([]string, len(r.Groups))
+		for i, group := range r.Groups {
+			if group == "" {
+				return nil, fmt.Errorf("empty group")
+			}
+			groups[i] = "inGroup(" + perlQuote(group) + ")"
+		}
+		conditions = append(conditions, anyOf(groups))
+	}
+	if len(r.IPs) > 0 {
+		ips := make([]string, len(r.IPs))
+		for i, ip := range r.IPs {
+			condition, err := ipCondition(ip)
+			if err != nil {
+				return nil, err
+			}
+			ips[i] = condition
+		}
+		conditions = append(conditions, anyOf(ips))
+	}
+	if r.AuthenticationLevel < 0 {
+		return nil, fmt.Errorf("invalid authenticationLevel %d", r.AuthenticationLevel)
+	}
+	if r.AuthenticationLevel > 0 {
+		conditions = append(conditions, fmt.Sprintf("$authenticationLevel >= %d", r.AuthenticationLevel))
+	}
+	if len(r.TimeWindows) > 0 {
+		windows := make([]string, len(r.TimeWindows))
+		for i, window := range r.TimeWindows {
+			condition, err := window.condition()
+			if err != nil {
+				return nil, err
+			}
+			windows[i] = condition
+		}
+		conditions = append(conditions, anyOf(windows))
+	}
+	return conditions, nil
+}
+
+// ipCondition returns the condition matching an IP address or CIDR
+func ipCondition(s string) (string, error) {
+	cidr := s
+	if !strings.Contains(cidr, "/") {
+		if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+			cidr += "/32"
+		} else {
+			cidr += "/128"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP or CIDR %q", s)
+	}
+	if ipNet.IP.To4() == nil {
+		return "isInNet6($ipAddr, " + perlQuote(ipNet.String()) + ")", nil
+	}
+	return "$ipAddr =~ /" + ipv4Regex(ipNet) + "/", nil
+}
+
+// ipv4Regex returns a regex matching the addresses of an IPv4 network
+func ipv4Regex(ipNet *net.IPNet) string {
+	ip := ipNet.IP.To4()
+	ones, _ := ipNet.Mask.Size()
+	parts := make([]string, 4)
+	for i := range parts {
+		bits := ones - 8*i
+		switch {
+		case bits >= 8:
+			parts[i] = strconv.Itoa(int(ip[i]))
+		case bits <= 0:
+			parts[i] = `\d+`
+		default:
+			first := int(ip[i])
+			values := []string{}
+			for n := first; n < first+1<<uint(8-bits); n++ {
+				values = append(values, strconv.Itoa(n))
+			}
+			parts[i] = "(?:" + strings.Join(values, "|") + ")"
+		}
+	}
+	return "^" + strings.Join(parts, `\.`) + "$"
+}
+
+// condition returns the condition matching the time window
+func (w *TimeWindow) condition() (string, error) {
+	conditions := []string{}
+	if len(w.Days) > 0 {
+		days := make([]bool, 7)
+		for _, day := range w.Days {
+			bounds := strings.SplitN(day, "-", 2)
+			first, ok := weekDays[bounds[0]]
+			if !ok {
+				return "", fmt.Errorf("invalid day %q", day)
+			}
+			last := first
+			if len(bounds) == 2 {
+				if last, ok = weekDays[bounds[1]]; !ok {
+					return "", fmt.Errorf("invalid day %q", day)
+				}
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+		class := ""
+		for d, ok := range days {
+			if ok {
+				class += strconv.Itoa(d)
+			}
+		}
+		conditions = append(conditions, "(localtime)[6] =~ /^["+class+"]$/")
+	}
+	from, err := parseTime(w.From)
+	if err != nil {
+		return "", err
+	}
+	to, err := parseTime(w.To)
+	if err != nil {
+		return "", err
+	}
+	now := "(localtime)[2] * 100 + (localtime)[1]"
+	if from <= to {
+		conditions = append(conditions, fmt.Sprintf("%s >= %d and %s < %d", now, from, now, to))
+	} else {
+		conditions = append(conditions, fmt.Sprintf("(%s >= %d or %s < %d)", now, from, now, to))
+	}
+	return "(" + strings.Join(conditions, " and ") + ")", nil
+}
+
+// parseTime returns a HH:MM time as HHMM
+func parseTime(t string) (int, error) {
+	m := timeRE.FindStringSubmatch(t)
+	if m == nil {
+		return 0, fmt.Errorf("invalid time %q, should be HH:MM", t)
+	}
+	hours, _ := strconv.Atoi(m[1])
+	minutes, _ := strconv.Atoi(m[2])
+	return hours*100 + minutes, nil
+}
+
+// anyOf returns a condition matching any of conditions
+func anyOf(conditions []string) string {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return "(" + strings.Join(conditions, " or ") + ")"
+}
+
+// perlQuote returns s as a Perl single-quoted string
+func perlQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `'`, `\'`, -1)
+	return "'" + s + "'"
+}
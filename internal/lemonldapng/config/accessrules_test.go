@@ -0,0 +1,133 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func assertCompile(t *testing.T, r AccessRule, expected string) {
+	rule, err := r.Compile()
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if rule != expected {
+		t.Errorf("Expected %q, got %q", expected, rule)
+	}
+}
+
+func assertCompileError(t *testing.T, r AccessRule, expected string) {
+	_, err := r.Compile()
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+}
+
+func TestAccessRuleActions(t *testing.T) {
+	assertCompile(t, AccessRule{}, "accept")
+	assertCompile(t, AccessRule{Action: "deny"}, "deny")
+	assertCompile(t, AccessRule{Action: "skip"}, "skip")
+	assertCompile(t, AccessRule{Action: "unprotect"}, "unprotect")
+	assertCompile(t, AccessRule{Action: "logout"}, "logout_sso")
+	assertCompile(t, AccessRule{Action: "logout_app"}, "logout_app")
+	assertCompileError(t, AccessRule{Action: "allow"}, `unsupported action "allow"`)
+	assertCompileError(t, AccessRule{Action: "skip", Users: []string{"bart"}}, "action skip does not accept conditions")
+}
+
+func TestAccessRuleUsers(t *testing.T) {
+	assertCompile(t, AccessRule{Users: []string{"bart"}}, `$uid eq 'bart'`)
+	assertCompile(t, AccessRule{Users: []string{"bart", "o'brien"}}, `($uid eq 'bart' or $uid eq 'o\'brien')`)
+	assertCompile(t, AccessRule{Action: "deny", Users: []string{"bart"}}, `not ($uid eq 'bart')`)
+	assertCompileError(t, AccessRule{Users: []string{""}}, "empty user")
+}
+
+func TestAccessRuleGroups(t *testing.T) {
+	assertCompile(t, AccessRule{Groups: []string{"admins"}}, `inGroup('admins')`)
+	assertCompile(t, AccessRule{Groups: []string{"admins", `back\slash`}}, `(inGroup('admins') or inGroup('back\\slash'))`)
+	assertCompileError(t, AccessRule{Groups: []string{""}}, "empty group")
+}
+
+func TestAccessRuleIPs(t *testing.T) {
+	assertCompile(t, AccessRule{IPs: []string{"192.168.1.10"}}, `$ipAddr =~ /^192\.168\.1\.10$/`)
+	assertCompile(t, AccessRule{IPs: []string{"10.0.0.0/8"}}, `$ipAddr =~ /^10\.\d+\.\d+\.\d+$/`)
+	assertCompile(t, AccessRule{IPs: []string{"172.16.0.0/14"}}, `$ipAddr =~ /^172\.(?:16|17|18|19)\.\d+\.\d+$/`)
+	assertCompile(t, AccessRule{IPs: []string{"fd00::/8"}}, `isInNet6($ipAddr, 'fd00::/8')`)
+	assertCompile(t, AccessRule{IPs: []string{"10.0.0.0/8", "::1"}}, `($ipAddr =~ /^10\.\d+\.\d+\.\d+$/ or isInNet6($ipAddr, '::1/128'))`)
+	assertCompileError(t, AccessRule{IPs: []string{"10.0.0.300"}}, `invalid IP or CIDR "10.0.0.300"`)
+}
+
+func TestAccessRuleAuthenticationLevel(t *testing.T) {
+	assertCompile(t, AccessRule{AuthenticationLevel: 3}, `$authenticationLevel >= 3`)
+	assertCompileError(t, AccessRule{AuthenticationLevel: -1}, "invalid authenticationLevel -1")
+}
+
+func TestAccessRuleTimeWindows(t *testing.T) {
+	assertCompile(t, AccessRule{TimeWindows: []TimeWindow{{From: "08:00", To: "18:30"}}},
+		`((localtime)[2] * 100 + (localtime)[1] >= 800 and (localtime)[2] * 100 + (localtime)[1] < 1830)`)
+	assertCompile(t, AccessRule{TimeWindows: []TimeWindow{{Days: []string{"mon-fri"}, From: "22:00", To: "6:00"}}},
+		`((localtime)[6] =~ /^[12345]$/ and ((localtime)[2] * 100 + (localtime)[1] >= 2200 or (localtime)[2] * 100 + (localtime)[1] < 600))`)
+	assertCompile(t, AccessRule{TimeWindows: []TimeWindow{{Days: []string{"fri-mon"}, From: "00:00", To: "23:59"}}},
+		`((localtime)[6] =~ /^[0156]$/ and (localtime)[2] * 100 + (localtime)[1] >= 0 and (localtime)[2] * 100 + (localtime)[1] < 2359)`)
+	assertCompileError(t, AccessRule{TimeWindows: []TimeWindow{{Days: []string{"monday"}, From: "08:00", To: "18:00"}}}, `invalid day "monday"`)
+	assertCompileError(t, AccessRule{TimeWindows: []TimeWindow{{From: "8h", To: "18:00"}}}, `invalid time "8h", should be HH:MM`)
+}
+
+func TestAccessRuleCombined(t *testing.T) {
+	assertCompile(t, AccessRule{
+		Action:              "deny",
+		Groups:              []string{"interns"},
+		IPs:                 []string{"10.1.0.0/16"},
+		AuthenticationLevel: 2,
+	}, `not (inGroup('interns') and $ipAddr =~ /^10\.1\.\d+\.\d+$/ and $authenticationLevel >= 2)`)
+}
+
+func TestParseAccessRules(t *testing.T) {
+	locationRules, err := ParseAccessRules(`
+- path: ^/admin/
+  comment: admins
+  groups: [admins]
+- path: ^/static/
+  action: skip
+- path: default
+`)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := map[string]string{
+		"(?#0 admins)^/admin/": `inGroup('admins')`,
+		"(?#1)^/static/":       "skip",
+		"default":              "accept",
+	}
+	if !reflect.DeepEqual(locationRules, expected) {
+		t.Errorf("Expected %v, got %v", expected, locationRules)
+	}
+
+	_, err = ParseAccessRules(`
+- path: ^/admin/
+  action: skip
+  users: [bart]
+`)
+	if err == nil || err.Error() != "Access rule 0 (^/admin/): action skip does not accept conditions" {
+		t.Errorf("Expected conditions error, got %v", err)
+	}
+
+	_, err = ParseAccessRules(`[{path: default, rule: accept}]`)
+	if err == nil {
+		t.Errorf("Expected unknown field error")
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultAnnotationsPrefix is the default prefix of annotations
+const DefaultAnnotationsPrefix = "kubernetes-controller.lemonldap-ng.org"
+
+// AnnotationsVersion is the version of the versioned annotations namespace,
+// "<version>.<prefix>/<name>"
+const AnnotationsVersion = "v1"
+
+// DeprecatedAnnotation is a legacy annotation in use, with its replacement
+type DeprecatedAnnotation struct {
+	Legacy      string
+	Replacement string
+}
+
+// NormalizeAnnotations returns the annotations of prefix keyed by
+// "<prefix>/<name>", reading the versioned name first and the legacy name
+// otherwise. Other annotations are dropped. It also returns the legacy
+// annotations in use, sorted
+func NormalizeAnnotations(annotations map[string]string, prefix string) (map[string]string, []DeprecatedAnnotation) {
+	normalized := make(map[string]string)
+	deprecated := []DeprecatedAnnotation{}
+	versionedPrefix := AnnotationsVersion + "." + prefix + "/"
+	legacyPrefix := prefix + "/"
+	for k, v := range annotations {
+		if strings.HasPrefix(k, versionedPrefix) {
+			normalized[legacyPrefix+strings.TrimPrefix(k, versionedPrefix)] = v
+		}
+	}
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, legacyPrefix) {
+			continue
+		}
+		replacement := versionedPrefix + strings.TrimPrefix(k, legacyPrefix)
+		deprecated = append(deprecated, DeprecatedAnnotation{
+			Legacy:      k,
+			Replacement: replacement,
+		})
+		if _, ok := annotations[replacement]; !ok {
+			normalized[k] = v
+		}
+	}
+	sort.Slice(deprecated, func(i, j int) bool {
+		return deprecated[i].Legacy < deprecated[j].Legacy
+	})
+	return normalized, deprecated
+}
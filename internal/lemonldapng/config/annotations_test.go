@@ -0,0 +1,48 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeAnnotations(t *testing.T) {
+	normalized, deprecated := NormalizeAnnotations(map[string]string{
+		"llng.example.org/location-rules":                   "legacy",
+		"v1.llng.example.org/location-rules":                "versioned",
+		"llng.example.org/exported-headers":                 "legacy headers",
+		"v1.llng.example.org/application-name":              "App",
+		"kubernetes.io/ingress.class":                       "nginx",
+		"kubernetes-controller.lemonldap-ng.org/vhost-port": "443",
+	}, "llng.example.org")
+	expectedNormalized := map[string]string{
+		"llng.example.org/location-rules":   "versioned",
+		"llng.example.org/exported-headers": "legacy headers",
+		"llng.example.org/application-name": "App",
+	}
+	if !reflect.DeepEqual(normalized, expectedNormalized) {
+		t.Errorf("Expected %v, got %v", expectedNormalized, normalized)
+	}
+	expectedDeprecated := []DeprecatedAnnotation{
+		{Legacy: "llng.example.org/exported-headers", Replacement: "v1.llng.example.org/exported-headers"},
+		{Legacy: "llng.example.org/location-rules", Replacement: "v1.llng.example.org/location-rules"},
+	}
+	if !reflect.DeepEqual(deprecated, expectedDeprecated) {
+		t.Errorf("Expected %v, got %v", expectedDeprecated, deprecated)
+	}
+}
@@ -16,14 +16,35 @@ limitations under the License.
 
 package config
 
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var languageRE = regexp.MustCompile(`^[a-z]{2,3}(_[A-Z]{2})?$`)
+
 // Application defines a LemonLDAP::NG application
 type Application struct {
 	Category    string
 	Name        string
 	Description string
 	Logo        string
-	Display     string
-	URI         string
+	// Display is auto, on, off or a rule expression
+	Display string
+	URI     string
+	Tooltip string
+	// Descriptions and Tooltips are the localized descriptions and tooltips,
+	// by language
+	Descriptions map[string]string
+	Tooltips     map[string]string
+	// Order sorts the application in its category, 0 meaning unset
+	Order int
+	// Owner is the namespace/name of the Ingress defining the application
+	Owner string
 }
 
 // NewApplication creates a new LemonLDAP::NG application from annotations
@@ -45,7 +66,7 @@ func NewApplication(vhost *VHost, annotations map[string]string, prefix string)
 	}
 	logo, ok := annotations[prefix+"/application-logo"]
 	if !ok {
-		logo = "gear.png"
+		logo = DefaultLogo
 	}
 	display, ok := annotations[prefix+"/application-display"]
 	if !ok {
@@ -53,19 +74,240 @@ func NewApplication(vhost *VHost, annotations map[string]string, prefix string)
 	}
 	uri, ok := annotations[prefix+"/application-uri"]
 	if !ok {
-		uri = "https://" + vhost.ServerName + "/" // FIXME http/https
+		uri = vhost.URL()
 	}
 	return &Application{
-		Category:    category,
-		Name:        name,
-		Description: description,
-		Logo:        logo,
-		Display:     display,
-		URI:         uri,
+		Category:     category,
+		Name:         name,
+		Description:  description,
+		Logo:         logo,
+		Display:      display,
+		URI:          uri,
+		Tooltip:      annotations[prefix+"/application-tooltip"],
+		Descriptions: localizedAnnotations(annotations, prefix+"/application-description."),
+		Tooltips:     localizedAnnotations(annotations, prefix+"/application-tooltip."),
+	}
+}
+
+// localizedAnnotations returns the values of the annotations prefixed by
+// prefix, by language
+func localizedAnnotations(annotations map[string]string, prefix string) map[string]string {
+	var values map[string]string
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[strings.TrimPrefix(k, prefix)] = v
+	}
+	return values
+}
+
+// toConfig returns the LemonLDAP::NG applicationList entry
+func (a *Application) toConfig() map[string]interface{} {
+	options := map[string]string{
+		"description": a.Description,
+		"display":     a.Display,
+		"logo":        a.Logo,
+		"name":        a.Name,
+		"uri":         a.URI,
+	}
+	if a.Tooltip != "" {
+		options["tooltip"] = a.Tooltip
+	}
+	for lang, description := range a.Descriptions {
+		options["description_"+lang] = description
+	}
+	for lang, tooltip := range a.Tooltips {
+		options["tooltip_"+lang] = tooltip
+	}
+	entry := map[string]interface{}{
+		"type":    "application",
+		"options": options,
+	}
+	if a.Order > 0 {
+		entry["order"] = a.Order
+	}
+	return entry
+}
+
+// validate checks the application category, name, order, display and
+// languages
+func (a *Application) validate() error {
+	for _, key := range strings.Split(a.Category, CategorySeparator) {
+		if key == "" {
+			return fmt.Errorf("Invalid category %q of application %s: should not have empty parts", a.Category, a.Name)
+		}
+	}
+	// Path would not be unique
+	if strings.Contains(a.Name, CategorySeparator) {
+		return fmt.Errorf("Invalid name %q of application %s: should not contain %s", a.Name, a.Path(), CategorySeparator)
+	}
+	if a.Order < 0 {
+		return fmt.Errorf("Invalid order %d of application %s: should be positive", a.Order, a.Path())
+	}
+	switch a.Display {
+	case "auto", "on", "off":
+	default:
+		if err := ValidateRule(a.Display); err != nil {
+			return fmt.Errorf("Invalid display %q of application %s: %s", a.Display, a.Path(), err)
+		}
 	}
+	for _, localized := range []map[string]string{a.Descriptions, a.Tooltips} {
+		for lang := range localized {
+			if !languageRE.MatchString(lang) {
+				return fmt.Errorf("Invalid language %q of application %s: should be like fr or pt_BR", lang, a.Path())
+			}
+		}
+	}
+	return nil
 }
 
 // Path returns the application path in the menu
 func (a *Application) Path() string {
 	return a.Category + "/" + a.Name
 }
+
+// ApplicationSpec defines an application of the applications annotation
+type ApplicationSpec struct {
+	Category    string `yaml:"category"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Logo        string `yaml:"logo,omitempty"`
+	Display     string `yaml:"display,omitempty"`
+	Order       int    `yaml:"order,omitempty"`
+	Tooltip     string `yaml:"tooltip,omitempty"`
+	// Descriptions and Tooltips are localized, by language
+	Descriptions map[string]string `yaml:"descriptions,omitempty"`
+	Tooltips     map[string]string `yaml:"tooltips,omitempty"`
+	// URI is the application URI. When empty, it is built from Host and Path
+	URI string `yaml:"uri,omitempty"`
+	// Host is one of the Ingress hosts, the first one when empty
+	Host string `yaml:"host,omitempty"`
+	Path string `yaml:"path,omitempty"`
+}
+
+// application creates the application of spec, with default description,
+// logo and display
+func (spec *ApplicationSpec) application() *Application {
+	application := &Application{
+		Category:     spec.Category,
+		Name:         spec.Name,
+		Description:  spec.Description,
+		Logo:         spec.Logo,
+		Display:      spec.Display,
+		URI:          spec.URI,
+		Order:        spec.Order,
+		Tooltip:      spec.Tooltip,
+		Descriptions: spec.Descriptions,
+		Tooltips:     spec.Tooltips,
+	}
+	if application.Description == "" {
+		application.Description = spec.Name
+	}
+	if application.Logo == "" {
+		application.Logo = DefaultLogo
+	}
+	if application.Display == "" {
+		application.Display = "auto"
+	}
+	return application
+}
+
+// NewApplications creates LemonLDAP::NG applications from the
+// application-* annotations and the applications list annotation. vhosts are
+// the Ingress virtual hosts, and vhost the one of applications without host
+func NewApplications(vhosts map[string]*VHost, vhost *VHost, annotations map[string]string, prefix string) ([]*Application, error) {
+	applications := []*Application{}
+	if application := NewApplication(vhost, annotations, prefix); application != nil {
+		if value, ok := annotations[prefix+"/application-order"]; ok {
+			order, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid application-order %q: should be a number", value)
+			}
+			application.Order = order
+		}
+		if err := application.validate(); err != nil {
+			return nil, err
+		}
+		applications = append(applications, application)
+	}
+	in, ok := annotations[prefix+"/applications"]
+	if !ok {
+		return applications, nil
+	}
+	var specs []ApplicationSpec
+	if err := yaml.UnmarshalStrict([]byte(in), &specs); err != nil {
+		return nil, err
+	}
+	for i, spec := range specs {
+		if spec.Category == "" || spec.Name == "" {
+			return nil, fmt.Errorf("Application %d should have a category and a name", i)
+		}
+		application := spec.application()
+		if err := application.validate(); err != nil {
+			return nil, err
+		}
+		if application.URI == "" {
+			appVHost := vhost
+			if spec.Host != "" {
+				serverName, err := NormalizeServerName(spec.Host)
+				if err != nil {
+					return nil, fmt.Errorf("Application %s: %s", application.Path(), err)
+				}
+				appVHost = vhosts[serverName]
+				if appVHost == nil {
+					return nil, fmt.Errorf("Application %s: host %s is not a host of the Ingress", application.Path(), spec.Host)
+				}
+			}
+			if appVHost == nil || IsWildcard(appVHost.ServerName) {
+				return nil, fmt.Errorf("Application %s: uri is required without a non-wildcard host", application.Path())
+			}
+			if spec.Path != "" && !strings.HasPrefix(spec.Path, "/") {
+				return nil, fmt.Errorf("Application %s: path %q should start with /", application.Path(), spec.Path)
+			}
+			application.URI = strings.TrimSuffix(appVHost.URL(), "/") + spec.Path
+			if spec.Path == "" {
+				application.URI += "/"
+			}
+		}
+		for _, other := range applications {
+			if other.Path() == application.Path() {
+				return nil, fmt.Errorf("Application %s is defined twice", application.Path())
+			}
+		}
+		applications = append(applications, application)
+	}
+	return applications, nil
+}
+
+// ParseExternalApplications parses a list of applications not defined by an
+// Ingress, which require an uri
+func ParseExternalApplications(in string) ([]*Application, error) {
+	var specs []ApplicationSpec
+	if err := yaml.UnmarshalStrict([]byte(in), &specs); err != nil {
+		return nil, err
+	}
+	applications := []*Application{}
+	for i, spec := range specs {
+		if spec.Category == "" || spec.Name == "" || spec.URI == "" {
+			return nil, fmt.Errorf("External application %d should have a category, a name and an uri", i)
+		}
+		application := spec.application()
+		if spec.Host != "" || spec.Path != "" {
+			return nil, fmt.Errorf("External application %s should not have a host or a path", application.Path())
+		}
+		if err := application.validate(); err != nil {
+			return nil, err
+		}
+		for _, other := range applications {
+			if other.Path() == application.Path() {
+				return nil, fmt.Errorf("Application %s is defined twice", application.Path())
+			}
+		}
+		applications = append(applications, application)
+	}
+	return applications, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewApplications(t *testing.T) {
+	app := NewVHost("app.example.org", nil, nil)
+	app.TLS = true
+	admin := NewVHost("admin.example.org", nil, nil)
+	wildcard := NewVHost("*.example.org", nil, nil)
+	vhosts := map[string]*VHost{
+		app.ServerName:      app,
+		admin.ServerName:    admin,
+		wildcard.ServerName: wildcard,
+	}
+	annotations := map[string]string{
+		"example.org/application-category": "10apps",
+		"example.org/application-name":     "App",
+		"example.org/applications": `
+- category: 10apps
+  name: App admin
+  host: Admin.example.org
+  path: /console
+- category: 20tools
+  name: Docs
+  path: /docs/
+  logo: help.png
+- category: 20tools
+  name: Wiki
+  uri: https://wiki.example.org/
+`,
+	}
+	applications, err := NewApplications(vhosts, app, annotations, "example.org")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	expected := []Application{
+		{Category: "10apps", Name: "App", Description: "App", Logo: "gear.png", Display: "auto", URI: "https://app.example.org/"},
+		{Category: "10apps", Name: "App admin", Description: "App admin", Logo: "gear.png", Display: "auto", URI: "http://admin.example.org/console"},
+		{Category: "20tools", Name: "Docs", Description: "Docs", Logo: "help.png", Display: "auto", URI: "https://app.example.org/docs/"},
+		{Category: "20tools", Name: "Wiki", Description: "Wiki", Logo: "gear.png", Display: "auto", URI: "https://wiki.example.org/"},
+	}
+	if len(applications) != len(expected) {
+		t.Errorf("Expected %d applications, got %d", len(expected), len(applications))
+		return
+	}
+	for i, application := range applications {
+		if !reflect.DeepEqual(*application, expected[i]) {
+			t.Errorf("Expected %+v, got %+v", expected[i], *application)
+		}
+	}
+}
+
+func TestNewApplicationsErrors(t *testing.T) {
+	app := NewVHost("app.example.org", nil, nil)
+	wildcard := NewVHost("*.example.org", nil, nil)
+	vhosts := map[string]*VHost{
+		app.ServerName:      app,
+		wildcard.ServerName: wildcard,
+	}
+	for in, expected := range map[string]string{
+		`[{name: App}]`: "Application 0 should have a category and a name",
+		`[{category: apps, name: App, host: other.example.org}]`:     "Application apps/App: host other.example.org is not a host of the Ingress",
+		`[{category: apps, name: App, host: "*.example.org"}]`:       "Application apps/App: uri is required without a non-wildcard host",
+		`[{category: apps, name: App, path: docs}]`:                  `Application apps/App: path "docs" should start with /`,
+		`[{category: apps, name: App}, {category: apps, name: App}]`: "Application apps/App is defined twice",
+		`[{category: apps//admin, name: App}]`:                       `Invalid category "apps//admin" of application App: should not have empty parts`,
+		`[{category: apps, name: admin/App}]`:                        `Invalid name "admin/App" of application apps/admin/App: should not contain /`,
+	} {
+		_, err := NewApplications(vhosts, app, map[string]string{"example.org/applications": in}, "example.org")
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q for %s, got %v", expected, in, err)
+		}
+	}
+	if _, err := NewApplications(vhosts, app, map[string]string{"example.org/applications": `[{category: apps, name: App, url: /}]`}, "example.org"); err == nil {
+		t.Errorf("Expected unknown field error")
+	}
+	annotations := map[string]string{
+		"example.org/application-category": "apps",
+		"example.org/application-name":     "App",
+		"example.org/application-order":    "first",
+	}
+	if _, err := NewApplications(vhosts, app, annotations, "example.org"); err == nil || err.Error() != `Invalid application-order "first": should be a number` {
+		t.Errorf("Expected application-order error, got %v", err)
+	}
+	annotations["example.org/application-order"] = "2"
+	if applications, err := NewApplications(vhosts, app, annotations, "example.org"); err != nil || len(applications) != 1 || applications[0].Order != 2 {
+		t.Errorf("Expected application with order 2, got %v, %v", applications, err)
+	}
+}
+
+func TestApplicationDisplayAndLocalization(t *testing.T) {
+	app := NewVHost("app.example.org", nil, nil)
+	vhosts := map[string]*VHost{app.ServerName: app}
+	annotations := map[string]string{
+		"example.org/application-category":       "apps",
+		"example.org/application-name":           "App",
+		"example.org/application-display":        "inGroup('admins')",
+		"example.org/application-tooltip":        "Open App",
+		"example.org/application-description.fr": "Mon application",
+		"example.org/application-tooltip.pt_BR":  "Abrir App",
+		"example.org/applications": `
+- category: apps
+  name: Docs
+  display: $uid ne 'guest'
+  descriptions: {de: Dokumentation}
+`,
+	}
+	applications, err := NewApplications(vhosts, app, annotations, "example.org")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	options := applications[0].toConfig()["options"].(map[string]string)
+	expected := map[string]string{
+		"description":    "App",
+		"description_fr": "Mon application",
+		"display":        "inGroup('admins')",
+		"logo":           DefaultLogo,
+		"name":           "App",
+		"tooltip":        "Open App",
+		"tooltip_pt_BR":  "Abrir App",
+		"uri":            "http://app.example.org/",
+	}
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("Expected %v, got %v", expected, options)
+	}
+	if applications[1].Display != "$uid ne 'guest'" || applications[1].Descriptions["de"] != "Dokumentation" {
+		t.Errorf("Unexpected application %+v", applications[1])
+	}
+
+	delete(annotations, "example.org/applications")
+	annotations["example.org/application-display"] = "inGroup('admins'"
+	if _, err = NewApplications(vhosts, app, annotations, "example.org"); err == nil || err.Error() != `Invalid display "inGroup('admins'" of application apps/App: missing ')'` {
+		t.Errorf("Expected display error, got %v", err)
+	}
+	annotations["example.org/application-display"] = "on"
+	annotations["example.org/application-description.french"] = "Mon application"
+	if _, err = NewApplications(vhosts, app, annotations, "example.org"); err == nil || err.Error() != `Invalid language "french" of application apps/App: should be like fr or pt_BR` {
+		t.Errorf("Expected language error, got %v", err)
+	}
+}
+
+func TestParseExternalApplications(t *testing.T) {
+	applications, err := ParseExternalApplications(`
+- category: saas
+  name: Chat
+  uri: https://chat.example.com/
+  display: inGroup('staff')
+  logo: chat.png
+`)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	expected := []*Application{
+		{Category: "saas", Name: "Chat", Description: "Chat", Logo: "chat.png", Display: "inGroup('staff')", URI: "https://chat.example.com/"},
+	}
+	if !reflect.DeepEqual(applications, expected) {
+		t.Errorf("Expected %+v, got %+v", expected[0], applications)
+	}
+	for in, expected := range map[string]string{
+		`[{category: saas, name: Chat}]`:                                   "External application 0 should have a category, a name and an uri",
+		`[{category: saas, name: Chat, uri: "https://chat/", path: /}]`:    "External application saas/Chat should not have a host or a path",
+		`[{category: saas, name: Chat, uri: "https://chat/", display: (}]`: `Invalid display "(" of application saas/Chat: missing ')'`,
+	} {
+		if _, err = ParseExternalApplications(in); err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
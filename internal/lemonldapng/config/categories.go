@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CategorySeparator separates the nested category keys of an application
+// category
+const CategorySeparator = "/"
+
+// Category defines a LemonLDAP::NG portal menu category
+type Category struct {
+	// Name is the displayed name, the category key when empty
+	Name string `yaml:"name,omitempty"`
+	// Order sorts the category among its siblings, 0 meaning unset
+	Order      int                  `yaml:"order,omitempty"`
+	Categories map[string]*Category `yaml:"categories,omitempty"`
+}
+
+// ParseCategories parses the category definitions, by category key
+func ParseCategories(in string) (map[string]*Category, error) {
+	categories := make(map[string]*Category)
+	if err := yaml.UnmarshalStrict([]byte(in), &categories); err != nil {
+		return nil, err
+	}
+	if err := validateCategories(categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// validateCategories checks the category keys, recursively
+func validateCategories(categories map[string]*Category) error {
+	for key, category := range categories {
+		if key == "" || strings.Contains(key, CategorySeparator) {
+			return fmt.Errorf("Invalid category key %q: should be non-empty and without %s", key, CategorySeparator)
+		}
+		if category == nil {
+			continue
+		}
+		if category.Order < 0 {
+			return fmt.Errorf("Invalid order %d of category %s: should be positive", category.Order, key)
+		}
+		if err := validateCategories(category.Categories); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// categoryConfig returns the applicationList entry of the nested category
+// path, creating the missing categories from their definitions
+func categoryConfig(applicationList map[string]interface{}, path string, categories map[string]*Category) (map[string]interface{}, error) {
+	entries := applicationList
+	for _, key := range strings.Split(path, CategorySeparator) {
+		category := categories[key]
+		entry, ok := entries[key].(map[string]interface{})
+		if !ok {
+			if entries[key] != nil {
+				return nil, fmt.Errorf("Category %s should be a map, got %T", key, entries[key])
+			}
+			entry = map[string]interface{}{
+				"type":    "category",
+				"catname": key,
+			}
+			// Categories of the base configuration are left unchanged
+			if category != nil && category.Name != "" {
+				entry["catname"] = category.Name
+			}
+			if category != nil && category.Order > 0 {
+				entry["order"] = category.Order
+			}
+			entries[key] = entry
+		} else if entry["type"] != "category" {
+			return nil, fmt.Errorf("%s is not a category", key)
+		}
+		categories = nil
+		if category != nil {
+			categories = category.Categories
+		}
+		entries = entry
+	}
+	return entries, nil
+}
+
+// addMenuEntries adds the paths of the entries of applicationList, categories
+// and applications, below prefix
+func addMenuEntries(paths map[string]bool, applicationList map[string]interface{}, prefix string) {
+	for key, value := range applicationList {
+		entry, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := prefix + key
+		paths[path] = true
+		if entry["type"] == "category" {
+			addMenuEntries(paths, entry, path+CategorySeparator)
+		}
+	}
+}
+
+// menuConflict returns the path of the entry of applicationList which
+// prevents adding the application path: an existing entry at path, or an
+// entry which is not a category on the way
+func menuConflict(applicationList map[string]interface{}, path string) string {
+	entries := applicationList
+	keys := strings.Split(path, CategorySeparator)
+	for i, key := range keys {
+		value, exists := entries[key]
+		if !exists {
+			return ""
+		}
+		entry, ok := value.(map[string]interface{})
+		if i == len(keys)-1 || !ok || entry["type"] != "category" {
+			return strings.Join(keys[:i+1], CategorySeparator)
+		}
+		entries = entry
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	fakefs "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem/fake"
+)
+
+func TestParseCategories(t *testing.T) {
+	categories, err := ParseCategories(`
+apps:
+  name: Applications
+  order: 1
+  categories:
+    admin: {name: Administration, order: 2}
+tools: {}
+`)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := map[string]*Category{
+		"apps": {
+			Name:  "Applications",
+			Order: 1,
+			Categories: map[string]*Category{
+				"admin": {Name: "Administration", Order: 2},
+			},
+		},
+		"tools": {},
+	}
+	if !reflect.DeepEqual(categories, expected) {
+		t.Errorf("Expected %v, got %v", expected, categories)
+	}
+
+	for in, expected := range map[string]string{
+		`{apps/admin: {}}`:                           `Invalid category key "apps/admin": should be non-empty and without /`,
+		`{apps: {categories: {admin: {order: -1}}}}`: "Invalid order -1 of category admin: should be positive",
+	} {
+		if _, err = ParseCategories(in); err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+	if _, err = ParseCategories(`{apps: {catname: Applications}}`); err == nil {
+		t.Errorf("Expected unknown field error")
+	}
+}
+
+func TestSaveCategories(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	categories, err := ParseCategories(`{apps: {name: Applications, order: 1, categories: {admin: {name: Administration}}}}`)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	config.SetCategories(categories)
+	config.AddApplications([]*Application{
+		{Category: "apps/admin", Name: "Console", Description: "Console", Logo: "gear.png", Display: "auto", URI: "https://console.example.org/", Order: 3},
+		{Category: "tools", Name: "Wiki", Description: "Wiki", Logo: "gear.png", Display: "auto", URI: "https://wiki.example.org/"},
+	})
+	if err = config.Save(); err != nil {
+		t.Errorf("%s", err)
+	}
+	conf, err := config.Load("lmConf-2.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	applicationList := conf["applicationList"].(map[string]interface{})
+	apps := applicationList["apps"].(map[string]interface{})
+	if apps["catname"] != "Applications" || apps["order"] != float64(1) || apps["type"] != "category" {
+		t.Errorf("Unexpected apps category %v", apps)
+	}
+	admin := apps["admin"].(map[string]interface{})
+	if admin["catname"] != "Administration" || admin["type"] != "category" {
+		t.Errorf("Unexpected admin category %v", admin)
+	}
+	console := admin["Console"].(map[string]interface{})
+	if console["order"] != float64(3) || console["type"] != "application" {
+		t.Errorf("Unexpected Console application %v", console)
+	}
+	tools := applicationList["tools"].(map[string]interface{})
+	if _, ok := tools["order"]; ok || tools["catname"] != "tools" {
+		t.Errorf("Unexpected tools category %v", tools)
+	}
+	if _, ok := tools["Wiki"].(map[string]interface{})["order"]; ok {
+		t.Errorf("Unexpected Wiki order")
+	}
+}
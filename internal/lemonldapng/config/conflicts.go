@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/metrics"
+)
+
+// ReasonApplicationConflict is the reason of an application ignored because
+// another owner or the base configuration already defines it
+const ReasonApplicationConflict = "ApplicationConflict"
+
+// ReasonVHostConflict is the reason of a virtual host ignored because another
+// owner already defines it, without sharing it
+const ReasonVHostConflict = "VHostConflict"
+
+// ConfigMapOwnerPrefix prefixes the owner of applications defined in a
+// ConfigMap, which take precedence over the Ingress ones
+const ConfigMapOwnerPrefix = "ConfigMap "
+
+// Conflict defines an object of an owner ignored by the configuration
+type Conflict struct {
+	// Owner is the namespace/name of the Ingress
+	Owner   string
+	Reason  string
+	Message string
+}
+
+// ConflictHandler is called once for each new conflict
+type ConflictHandler func(conflict Conflict)
+
+// SetConflictHandler sets the handler of new conflicts
+func (c *Config) SetConflictHandler(handler ConflictHandler) {
+	c.Lock()
+	defer c.Unlock()
+	c.conflictHandler = handler
+}
+
+// Conflicts returns the conflicts of the last save
+func (c *Config) Conflicts() []Conflict {
+	c.RLock()
+	defer c.RUnlock()
+	conflicts := []Conflict{}
+	for _, conflict := range c.conflicts {
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts
+}
+
+// reportConflicts logs and reports the conflicts not already reported
+func (c *Config) reportConflicts(conflicts map[string]Conflict) {
+	for key, conflict := range conflicts {
+		if c.conflicts[key] == conflict {
+			continue
+		}
+		glog.Warningf("%s: %s", conflict.Owner, conflict.Message)
+		if c.conflictHandler != nil {
+			c.conflictHandler(conflict)
+		}
+	}
+	c.conflicts = conflicts
+	applicationConflicts := 0
+	for _, conflict := range conflicts {
+		if conflict.Reason == ReasonApplicationConflict {
+			applicationConflicts++
+		}
+	}
+	metrics.ApplicationConflicts.Set(float64(applicationConflicts))
+}
+
+// sortOwners sorts owners by name, ConfigMap owners first
+func sortOwners(owners []string) {
+	sort.Slice(owners, func(i, j int) bool {
+		iConfigMap := strings.HasPrefix(owners[i], ConfigMapOwnerPrefix)
+		jConfigMap := strings.HasPrefix(owners[j], ConfigMapOwnerPrefix)
+		if iConfigMap != jConfigMap {
+			return iConfigMap
+		}
+		return owners[i] < owners[j]
+	})
+}
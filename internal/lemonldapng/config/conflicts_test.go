@@ -0,0 +1,147 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	fakefs "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem/fake"
+)
+
+func TestApplicationConflicts(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	fs.WriteFile("/var/lib/lemonldap-ng/conf/lmConf-1.js", []byte(`{
+		"applicationList": {
+			"apps": {
+				"type": "category",
+				"catname": "Hand-written applications",
+				"Manager": {"type": "application", "options": {"name": "Manager", "uri": "https://manager.example.org/"}}
+			}
+		},
+		"cfgNum": 1,
+		"exportedHeaders": {},
+		"locationRules": {}
+	}`), 0644)
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	reported := []Conflict{}
+	config.SetConflictHandler(func(conflict Conflict) {
+		reported = append(reported, conflict)
+	})
+	manager := &Application{Category: "apps", Name: "Manager", URI: "https://other.example.org/", Owner: "ns1/manager"}
+	wiki1 := &Application{Category: "apps", Name: "Wiki", URI: "https://wiki1.example.org/", Owner: "ns1/wiki"}
+	wiki2 := &Application{Category: "apps", Name: "Wiki", URI: "https://wiki2.example.org/", Owner: "ns2/wiki"}
+	config.AddApplications([]*Application{manager, wiki2})
+	config.AddApplications([]*Application{wiki1})
+	if err := config.Save(); err != nil {
+		t.Errorf("%s", err)
+	}
+	conf, err := config.Load("lmConf-2.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	apps := conf["applicationList"].(map[string]interface{})["apps"].(map[string]interface{})
+	if apps["catname"] != "Hand-written applications" {
+		t.Errorf("Expected hand-written category name, got %v", apps["catname"])
+	}
+	if uri := apps["Manager"].(map[string]interface{})["options"].(map[string]interface{})["uri"]; uri != "https://manager.example.org/" {
+		t.Errorf("Expected hand-written Manager, got %v", uri)
+	}
+	if uri := apps["Wiki"].(map[string]interface{})["options"].(map[string]interface{})["uri"]; uri != "https://wiki1.example.org/" {
+		t.Errorf("Expected Wiki of first owner, got %v", uri)
+	}
+	expected := map[string]string{
+		"ns1/manager": "Application apps/Manager conflicts with the base configuration, ignoring it: Manager is already defined",
+		"ns2/wiki":    "Application apps/Wiki is already defined by ns1/wiki, ignoring it",
+	}
+	if len(reported) != len(expected) {
+		t.Errorf("Expected %d conflicts, got %v", len(expected), reported)
+	}
+	for _, conflict := range reported {
+		if conflict.Reason != ReasonApplicationConflict || conflict.Message != expected[conflict.Owner] {
+			t.Errorf("Unexpected conflict %+v", conflict)
+		}
+	}
+
+	// Conflicts are reported once, and deleting an owner keeps the others
+	reported = []Conflict{}
+	config.DeleteApplications([]*Application{wiki2})
+	if err = config.Save(); err != nil {
+		t.Errorf("%s", err)
+	}
+	if len(reported) != 0 || len(config.Conflicts()) != 1 {
+		t.Errorf("Expected no new conflict and 1 remaining, got %v and %v", reported, config.Conflicts())
+	}
+	config.AddApplications([]*Application{wiki2})
+	config.DeleteApplications([]*Application{wiki1})
+	if err = config.Save(); err != nil {
+		t.Errorf("%s", err)
+	}
+	conf, err = config.Load("lmConf-4.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	apps = conf["applicationList"].(map[string]interface{})["apps"].(map[string]interface{})
+	if uri := apps["Wiki"].(map[string]interface{})["options"].(map[string]interface{})["uri"]; uri != "https://wiki2.example.org/" {
+		t.Errorf("Expected Wiki of remaining owner, got %v", uri)
+	}
+}
+
+func TestApplicationCategoryConflicts(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	reported := []Conflict{}
+	config.SetConflictHandler(func(conflict Conflict) {
+		reported = append(reported, conflict)
+	})
+	config.AddApplications([]*Application{
+		{Category: "apps", Name: "admin", URI: "https://admin.example.org/", Owner: "ns1/admin"},
+		{Category: "apps/admin", Name: "Users", URI: "https://users.example.org/", Owner: "ns2/users"},
+	})
+	if err := config.Save(); err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := "Application apps/admin/Users conflicts with apps/admin defined by ns1/admin, ignoring it: admin is not a category"
+	if len(reported) != 1 || reported[0].Owner != "ns2/users" || reported[0].Message != expected {
+		t.Errorf("Expected conflict %q of ns2/users, got %v", expected, reported)
+	}
+}
+
+func TestConfigMapApplicationsPrecedence(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	config.AddApplications([]*Application{
+		{Category: "saas", Name: "Chat", URI: "https://chat.internal/", Owner: "0ns/chat"},
+		{Category: "saas", Name: "Chat", URI: "https://chat.example.com/", Owner: ConfigMapOwnerPrefix + "ingress-nginx/llng"},
+	})
+	if err := config.Save(); err != nil {
+		t.Errorf("%s", err)
+	}
+	conf, err := config.Load("lmConf-2.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	chat := conf["applicationList"].(map[string]interface{})["saas"].(map[string]interface{})["Chat"].(map[string]interface{})
+	if uri := chat["options"].(map[string]interface{})["uri"]; uri != "https://chat.example.com/" {
+		t.Errorf("Expected the ConfigMap application, got %v", uri)
+	}
+	if conflicts := config.Conflicts(); len(conflicts) != 1 || conflicts[0].Owner != "0ns/chat" {
+		t.Errorf("Expected a conflict of 0ns/chat, got %v", conflicts)
+	}
+}
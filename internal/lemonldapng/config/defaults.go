@@ -0,0 +1,79 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Defaults defines the default location rules and exported headers of a
+// scope (flags, ConfigMap, Namespace). Nil fields are not set
+type Defaults struct {
+	LocationRules   map[string]string
+	ExportedHeaders map[string]string
+}
+
+// ParseExportedHeaders parses exported headers, as a map of header name to
+// expression
+func ParseExportedHeaders(in string) (map[string]string, error) {
+	exportedHeaders := make(map[string]string)
+	if err := yaml.Unmarshal([]byte(in), &exportedHeaders); err != nil {
+		return nil, err
+	}
+	return exportedHeaders, nil
+}
+
+// NewDefaults parses defaults from YAML location rules and exported headers.
+// Empty strings are not set
+func NewDefaults(locationRules, exportedHeaders string) (Defaults, error) {
+	var defaults Defaults
+	var err error
+	if locationRules != "" {
+		defaults.LocationRules, err = ParseLocationRules(locationRules)
+		if err != nil {
+			return defaults, fmt.Errorf("Unable to parse default location rules: %s", err)
+		}
+	}
+	if exportedHeaders != "" {
+		defaults.ExportedHeaders, err = ParseExportedHeaders(exportedHeaders)
+		if err != nil {
+			return defaults, fmt.Errorf("Unable to parse default exported headers: %s", err)
+		}
+	}
+	return defaults, nil
+}
+
+// ResolveDefaults returns the first set location rules and exported headers
+// of scopes, by decreasing precedence, falling back to DefaultLocationRules and
+// DefaultExportedHeaders
+func ResolveDefaults(scopes ...Defaults) Defaults {
+	resolved := Defaults{
+		LocationRules:   DefaultLocationRules,
+		ExportedHeaders: DefaultExportedHeaders,
+	}
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if scopes[i].LocationRules != nil {
+			resolved.LocationRules = scopes[i].LocationRules
+		}
+		if scopes[i].ExportedHeaders != nil {
+			resolved.ExportedHeaders = scopes[i].ExportedHeaders
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveDefaults(t *testing.T) {
+	resolved := ResolveDefaults()
+	if !reflect.DeepEqual(resolved.LocationRules, DefaultLocationRules) || !reflect.DeepEqual(resolved.ExportedHeaders, DefaultExportedHeaders) {
+		t.Errorf("Expected built-in defaults, got %v", resolved)
+	}
+
+	flags, err := NewDefaults(`{"default": "$authenticationLevel >= 3"}`, "")
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	configMap, err := NewDefaults("", `{"Auth-Mail": "$mail"}`)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	namespace, err := NewDefaults(`{"default": "accept"}`, "")
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	resolved = ResolveDefaults(Defaults{}, configMap, flags)
+	expected := Defaults{
+		LocationRules:   map[string]string{"default": "$authenticationLevel >= 3"},
+		ExportedHeaders: map[string]string{"Auth-Mail": "$mail"},
+	}
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("Expected %v, got %v", expected, resolved)
+	}
+
+	resolved = ResolveDefaults(namespace, configMap, flags)
+	expected.LocationRules = map[string]string{"default": "accept"}
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("Expected %v, got %v", expected, resolved)
+	}
+
+	if _, err = NewDefaults("[", ""); err == nil {
+		t.Errorf("Expected location rules parse error")
+	}
+	if _, err = NewDefaults("", "- list"); err == nil {
+		t.Errorf("Expected exported headers parse error")
+	}
+}
@@ -19,10 +19,15 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/metrics"
 )
 
 var validConfigurationName = regexp.MustCompile(`^lmConf-(\d+)\.js$`)
@@ -35,9 +40,22 @@ type Config struct {
 	configDir    string
 	cfgNum       int
 	overrides    map[string]interface{}
-	vhosts       map[string]*VHost
-	applications map[string]*Application
+	vhosts       map[string]map[string]*VHost       // by server name, then owner
+	applications map[string]map[string]*Application // by path, then owner
+	categories   map[string]*Category
+	oidcRPs      map[string]map[string]*OIDCRelyingParty
+	samlSPs      map[string]map[string]*SAMLServiceProvider
 	dirty        bool
+	// saved is true once a configuration has been saved
+	saved bool
+
+	// conflicts are the application conflicts reported at the last save
+	conflicts       map[string]Conflict
+	conflictHandler ConflictHandler
+
+	defaultVHostPolicy string
+
+	lastReloadErr error
 }
 
 // NewConfig creates a new LemonLDAP::NG configuration loader
@@ -47,8 +65,13 @@ func NewConfig(fs filesystem.Filesystem, configDir string) *Config {
 		configDir:    configDir,
 		cfgNum:       1,
 		overrides:    make(map[string]interface{}),
-		vhosts:       make(map[string]*VHost),
-		applications: make(map[string]*Application),
+		vhosts:       make(map[string]map[string]*VHost),
+		applications: make(map[string]map[string]*Application),
+		oidcRPs:      make(map[string]map[string]*OIDCRelyingParty),
+		samlSPs:      make(map[string]map[string]*SAMLServiceProvider),
+		conflicts:    make(map[string]Conflict),
+
+		defaultVHostPolicy: DefaultVHostMerge,
 	}
 }
 
@@ -100,13 +123,39 @@ func (c *Config) LoadFirst() (map[string]interface{}, error) {
 	return c.Load(firstConfigName)
 }
 
-// Save saves the current LemonLDAP::NG configuration as next
+// Save saves the current LemonLDAP::NG configuration as next, and reloads
+// LemonLDAP::NG
 func (c *Config) Save() error {
 	c.Lock()
-	defer c.Unlock()
 	if !c.dirty {
+		c.Unlock()
 		return nil
 	}
+	start := time.Now()
+	err := c.saveNoLock()
+	metrics.ConfigSaveDuration.Observe(time.Since(start).Seconds())
+	metrics.ConfigSaves.WithLabelValues(metrics.Result(err)).Inc()
+	c.Unlock()
+	if err != nil {
+		return err
+	}
+	c.reload()
+	return nil
+}
+
+// Publish saves the LemonLDAP::NG configuration, even unchanged, unless it
+// has already been saved
+func (c *Config) Publish() error {
+	c.Lock()
+	if !c.saved {
+		c.dirty = true
+	}
+	c.Unlock()
+	return c.Save()
+}
+
+// saveNoLock saves the current LemonLDAP::NG configuration as next
+func (c *Config) saveNoLock() error {
 	nextConfigNum := c.cfgNum + 1
 	nextConfigName := fmt.Sprintf("lmConf-%d.js", c.cfgNum+1)
 	path := c.configDir + "/" + nextConfigName
@@ -137,35 +186,127 @@ func (c *Config) Save() error {
 	if !ok {
 		return fmt.Errorf("locationRules should be a map, got %T", conf["locationRules"])
 	}
-	for serverName, vhost := range c.vhosts {
+	allVHostOptions, ok := conf["vhostOptions"].(map[string]interface{})
+	if !ok {
+		if conf["vhostOptions"] != nil {
+			return fmt.Errorf("vhostOptions should be a map, got %T", conf["vhostOptions"])
+		}
+		allVHostOptions = make(map[string]interface{})
+		conf["vhostOptions"] = allVHostOptions
+	}
+	allPost, ok := conf["post"].(map[string]interface{})
+	if !ok {
+		if conf["post"] != nil {
+			return fmt.Errorf("post should be a map, got %T", conf["post"])
+		}
+		allPost = make(map[string]interface{})
+		conf["post"] = allPost
+	}
+	conflicts := make(map[string]Conflict)
+	vhosts := make(map[string]*VHost)
+	for serverName, owners := range c.vhosts {
+		// The default virtual host follows its policy, other ones are only
+		// merged when all owners share them
+		if serverName == DefaultServerName {
+			if c.defaultVHostPolicy == DefaultVHostFirst {
+				owners = firstOwner(owners, conflicts)
+			}
+		} else if !allShared(owners) {
+			owners = firstOwner(owners, conflicts)
+		}
+		vhosts[serverName] = mergeVHosts(owners)
+	}
+	for serverName, vhost := range vhosts {
 		allExportedHeaders[serverName] = vhost.ExportedHeaders
 		allLocationRules[serverName] = vhost.LocationRules
+		allVHostOptions[serverName] = vhost.Options.toConfig()
+		if len(vhost.FormReplays) > 0 {
+			post := make(map[string]interface{})
+			for _, formReplay := range vhost.FormReplays {
+				if _, ok = post[formReplay.URL]; ok {
+					continue
+				}
+				post[formReplay.URL] = formReplay.toConfig()
+			}
+			allPost[serverName] = post
+		}
+	}
+
+	// Secure the SSO cookie when every virtual host uses TLS, unless set in
+	// the base configuration or the overrides
+	if _, ok = conf["securedCookie"]; !ok && len(vhosts) > 0 {
+		allTLS := true
+		for _, vhost := range vhosts {
+			allTLS = allTLS && vhost.TLS
+		}
+		if allTLS {
+			conf["securedCookie"] = 1
+		}
 	}
 
 	allApplications, ok := conf["applicationList"].(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("applicationList should be a map, got %T", conf["applicationList"])
 	}
-	for _, a := range c.applications {
-		var cat map[string]interface{}
-		cat, ok = allApplications[a.Category].(map[string]interface{})
-		if !ok {
-			cat = make(map[string]interface{})
-			cat["type"] = "category"
-			cat["catname"] = a.Category
-			allApplications[a.Category] = cat
+	// baseEntries are the menu entries of the base configuration, the other
+	// ones are written below by their owner in entryOwners
+	baseEntries := map[string]bool{}
+	addMenuEntries(baseEntries, allApplications, "")
+	entryOwners := map[string]string{}
+	paths := []string{}
+	for path := range c.applications {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		owners := c.applications[path]
+		names := []string{}
+		for owner := range owners {
+			names = append(names, owner)
+		}
+		sortOwners(names)
+		for _, owner := range names[1:] {
+			conflicts[owner+" "+path] = Conflict{
+				Owner:   owner,
+				Reason:  ReasonApplicationConflict,
+				Message: fmt.Sprintf("Application %s is already defined by %s, ignoring it", path, names[0]),
+			}
+		}
+		a := owners[names[0]]
+		cat, err := categoryConfig(allApplications, a.Category, c.categories)
+		if err == nil {
+			if _, ok = cat[a.Name]; ok {
+				err = fmt.Errorf("%s is already defined", a.Name)
+			}
 		}
-		cat[a.Name] = map[string]interface{}{
-			"type": "application",
-			"options": map[string]string{
-				"description": a.Description,
-				"display":     a.Display,
-				"logo":        a.Logo,
-				"name":        a.Name,
-				"uri":         a.URI,
-			},
+		if err != nil {
+			message := fmt.Sprintf("Application %s conflicts with the base configuration, ignoring it: %s", path, err)
+			if clash := menuConflict(allApplications, path); !baseEntries[clash] && entryOwners[clash] != "" {
+				message = fmt.Sprintf("Application %s conflicts with %s defined by %s, ignoring it: %s", path, clash, entryOwners[clash], err)
+			}
+			conflicts[a.Owner+" "+path] = Conflict{
+				Owner:   a.Owner,
+				Reason:  ReasonApplicationConflict,
+				Message: message,
+			}
+			continue
 		}
+		cat[a.Name] = a.toConfig()
+		keys := strings.Split(path, CategorySeparator)
+		for i := range keys {
+			entry := strings.Join(keys[:i+1], CategorySeparator)
+			if _, ok = entryOwners[entry]; !ok && !baseEntries[entry] {
+				entryOwners[entry] = a.Owner
+			}
+		}
+	}
+	if err = c.saveOIDCRelyingParties(conf, conflicts); err != nil {
+		return err
 	}
+	if err = c.saveSAMLServiceProviders(conf, conflicts); err != nil {
+		return err
+	}
+	c.reportConflicts(conflicts)
 	content, err := json.MarshalIndent(conf, "", "   ")
 	if err != nil {
 		return fmt.Errorf("Unable to encode LemonLDAP::NG configuration file %s: %s", nextConfigName, err)
@@ -175,11 +316,47 @@ func (c *Config) Save() error {
 		return fmt.Errorf("Unable to write LemonLDAP::NG configuration file %s: %s", path, err)
 	}
 	c.cfgNum++
-	c.ReloadLemonLDAPNG()
+	metrics.ConfigNumber.Set(float64(c.cfgNum))
+	metrics.VHosts.Set(float64(len(c.vhosts)))
+	metrics.Applications.Set(float64(len(c.applications)))
 	c.dirty = false
+	c.saved = true
 	return nil
 }
 
+// Published returns true when a configuration has been saved, with all
+// changes
+func (c *Config) Published() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.saved && !c.dirty
+}
+
+// LastReloadError returns the error of the last LemonLDAP::NG reload, if any
+func (c *Config) LastReloadError() error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.lastReloadErr
+}
+
+// RetryReload reloads LemonLDAP::NG again if the last reload failed
+func (c *Config) RetryReload() error {
+	if c.LastReloadError() == nil {
+		return nil
+	}
+	return c.reload()
+}
+
+// reload reloads LemonLDAP::NG and records the error, without holding the
+// lock during the request
+func (c *Config) reload() error {
+	err := c.ReloadLemonLDAPNG()
+	c.Lock()
+	c.lastReloadErr = err
+	c.Unlock()
+	return err
+}
+
 // stringifyKeysMapValue recurses into in and changes all instances of
 // map[interface{}]interface{} to map[string]interface{}. This is useful to
 // work around the impedence mismatch between JSON and YAML unmarshaling that's
@@ -224,16 +401,33 @@ func (c *Config) SetOverrides(overrides map[string]interface{}) error {
 	return nil
 }
 
+// SetCategories sets the category definitions of the portal menu
+func (c *Config) SetCategories(categories map[string]*Category) {
+	c.Lock()
+	defer c.Unlock()
+	c.categories = categories
+	c.dirty = true
+}
+
+// SetDefaultVHostPolicy sets how the default virtual host of several owners
+// is saved, DefaultVHostMerge or DefaultVHostFirst. It applies from the next
+// save
+func (c *Config) SetDefaultVHostPolicy(policy string) {
+	c.Lock()
+	defer c.Unlock()
+	c.defaultVHostPolicy = policy
+}
+
 // AddVHosts creates several new LemonLDAP::NG virtual hosts
 func (c *Config) AddVHosts(vhosts map[string]*VHost) error {
 	c.Lock()
 	defer c.Unlock()
 	for _, vhost := range vhosts {
-		c.vhosts[vhost.ServerName] = &VHost{
-			vhost.ServerName,
-			vhost.LocationRules,
-			vhost.ExportedHeaders,
+		v := *vhost
+		if c.vhosts[vhost.ServerName] == nil {
+			c.vhosts[vhost.ServerName] = make(map[string]*VHost)
 		}
+		c.vhosts[vhost.ServerName][vhost.Owner] = &v
 	}
 	c.dirty = true
 	return nil
@@ -244,32 +438,127 @@ func (c *Config) DeleteVHosts(vhosts map[string]*VHost) error {
 	c.Lock()
 	defer c.Unlock()
 	for _, vhost := range vhosts {
-		delete(c.vhosts, vhost.ServerName)
+		delete(c.vhosts[vhost.ServerName], vhost.Owner)
+		if len(c.vhosts[vhost.ServerName]) == 0 {
+			delete(c.vhosts, vhost.ServerName)
+		}
 	}
 	c.dirty = true
 	return nil
 }
 
-// AddApplication creates a new LemonLDAP::NG application
-func (c *Config) AddApplication(application *Application) error {
-	if application == nil {
+// AddApplications creates or updates several LemonLDAP::NG applications,
+// unless unchanged
+func (c *Config) AddApplications(applications []*Application) error {
+	c.Lock()
+	defer c.Unlock()
+	for _, application := range applications {
+		a := *application
+		if existing := c.applications[a.Path()][a.Owner]; existing != nil && reflect.DeepEqual(*existing, a) {
+			continue
+		}
+		if c.applications[a.Path()] == nil {
+			c.applications[a.Path()] = make(map[string]*Application)
+		}
+		c.applications[a.Path()][a.Owner] = &a
+		c.dirty = true
+	}
+	return nil
+}
+
+// DeleteApplications deletes several LemonLDAP::NG applications
+func (c *Config) DeleteApplications(applications []*Application) error {
+	c.Lock()
+	defer c.Unlock()
+	for _, application := range applications {
+		delete(c.applications[application.Path()], application.Owner)
+		if len(c.applications[application.Path()]) == 0 {
+			delete(c.applications, application.Path())
+		}
+	}
+	c.dirty = true
+	return nil
+}
+
+// AddOIDCRelyingParty creates or updates the LemonLDAP::NG OIDC Relying Party
+// of an owner. When several owners define the same name, the first one is kept
+func (c *Config) AddOIDCRelyingParty(rp *OIDCRelyingParty) error {
+	if rp == nil {
 		return nil
 	}
 	c.Lock()
 	defer c.Unlock()
-	c.applications[application.Path()] = application
+	if c.oidcRPs[rp.Name] == nil {
+		c.oidcRPs[rp.Name] = make(map[string]*OIDCRelyingParty)
+	}
+	c.oidcRPs[rp.Name][rp.Owner] = rp
 	c.dirty = true
 	return nil
 }
 
-// DeleteApplication a LemonLDAP::NG application
-func (c *Config) DeleteApplication(application *Application) error {
-	if application == nil {
+// DeleteOIDCRelyingParty deletes the LemonLDAP::NG OIDC Relying Party of an
+// owner, if defined
+func (c *Config) DeleteOIDCRelyingParty(rp *OIDCRelyingParty) error {
+	if rp == nil {
 		return nil
 	}
 	c.Lock()
 	defer c.Unlock()
-	delete(c.applications, application.Path())
+	if _, ok := c.oidcRPs[rp.Name][rp.Owner]; !ok {
+		return nil
+	}
+	delete(c.oidcRPs[rp.Name], rp.Owner)
+	if len(c.oidcRPs[rp.Name]) == 0 {
+		delete(c.oidcRPs, rp.Name)
+	}
+	c.dirty = true
+	return nil
+}
+
+// HasOIDCRelyingParty tells whether the OIDC Relying Party of an owner is
+// defined
+func (c *Config) HasOIDCRelyingParty(rp *OIDCRelyingParty) bool {
+	if rp == nil {
+		return false
+	}
+	c.RLock()
+	defer c.RUnlock()
+	_, ok := c.oidcRPs[rp.Name][rp.Owner]
+	return ok
+}
+
+// AddSAMLServiceProvider creates or updates the LemonLDAP::NG SAML Service
+// Provider of an owner. When several owners define the same name, the first
+// one is kept
+func (c *Config) AddSAMLServiceProvider(sp *SAMLServiceProvider) error {
+	if sp == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	if reflect.DeepEqual(c.samlSPs[sp.Name][sp.Owner], sp) {
+		return nil
+	}
+	if c.samlSPs[sp.Name] == nil {
+		c.samlSPs[sp.Name] = make(map[string]*SAMLServiceProvider)
+	}
+	c.samlSPs[sp.Name][sp.Owner] = sp
+	c.dirty = true
+	return nil
+}
+
+// DeleteSAMLServiceProvider deletes the LemonLDAP::NG SAML Service Provider
+// name of an owner, if defined
+func (c *Config) DeleteSAMLServiceProvider(name, owner string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.samlSPs[name][owner]; !ok {
+		return nil
+	}
+	delete(c.samlSPs[name], owner)
+	if len(c.samlSPs[name]) == 0 {
+		delete(c.samlSPs, name)
+	}
 	c.dirty = true
 	return nil
 }
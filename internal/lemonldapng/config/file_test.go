@@ -17,11 +17,15 @@ limitations under the License.
 package config
 
 import (
+	"bytes"
 	"flag"
 	"regexp"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
 	fakefs "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem/fake"
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/metrics"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -192,3 +196,331 @@ func TestInvalidLocationRules(t *testing.T) {
 		t.Errorf("Expected 'exportedHeaders should be a map, got <nil>', got %q", errSave2)
 	}
 }
+
+func TestPublished(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	if config.Published() {
+		t.Errorf("Expected a new configuration not to be published")
+	}
+	if config.LastReloadError() != nil {
+		t.Errorf("Expected no reload error before the first save, got %q", config.LastReloadError())
+	}
+
+	config.AddVHosts(map[string]*VHost{
+		"test42.example.org": NewVHost("test42.example.org", DefaultLocationRules, DefaultExportedHeaders),
+	})
+	if config.Published() {
+		t.Errorf("Expected unsaved changes not to be published")
+	}
+	errSave := config.Save()
+	if errSave != nil {
+		t.Errorf("%s", errSave)
+	}
+	if !config.Published() {
+		t.Errorf("Expected saved changes to be published")
+	}
+
+	// An unchanged configuration is published once
+	config = NewConfig(fakefs.NewFilesystem(), "/var/lib/lemonldap-ng/conf")
+	if err := config.Publish(); err != nil {
+		t.Errorf("%s", err)
+	}
+	if !config.Published() {
+		t.Errorf("Expected the configuration to be published")
+	}
+	if err := config.Publish(); err != nil {
+		t.Errorf("%s", err)
+	}
+	if _, cfgNum, _ := config.Last(); cfgNum != 2 {
+		t.Errorf("Expected configuration to be published once, got cfgNum %d", cfgNum)
+	}
+}
+
+func TestSaveMetrics(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	saves := testutil.ToFloat64(metrics.ConfigSaves.WithLabelValues("success"))
+
+	config.AddVHosts(map[string]*VHost{
+		"test42.example.org": NewVHost("test42.example.org", DefaultLocationRules, DefaultExportedHeaders),
+	})
+	errSave := config.Save()
+	if errSave != nil {
+		t.Errorf("%s", errSave)
+	}
+	if value := testutil.ToFloat64(metrics.ConfigSaves.WithLabelValues("success")); value != saves+1 {
+		t.Errorf("Expected %v successful saves, got %v", saves+1, value)
+	}
+	if value := testutil.ToFloat64(metrics.ConfigNumber); value != 2 {
+		t.Errorf("Expected configuration number 2, got %v", value)
+	}
+	if value := testutil.ToFloat64(metrics.VHosts); value != 1 {
+		t.Errorf("Expected 1 virtual host, got %v", value)
+	}
+}
+
+func TestVHostOptions(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	prefix := "kubernetes-controller.lemonldap-ng.org"
+	options, err := NewVHostOptions(map[string]string{
+		prefix + "/vhost-port":        "8443",
+		prefix + "/vhost-https":       "true",
+		prefix + "/vhost-maintenance": "false",
+		prefix + "/vhost-aliases":     "test43.example.org, test44.example.org",
+		prefix + "/vhost-type":        "DevOps",
+		prefix + "/vhost-authn-level": "3",
+	}, prefix, false)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	for _, c := range []struct {
+		annotation string
+		value      string
+	}{
+		{"vhost-port", "http"},
+		{"vhost-port", "0"},
+		{"vhost-port", "-2"},
+		{"vhost-port", "65536"},
+		{"vhost-https", "maybe"},
+		{"vhost-maintenance", "2"},
+		{"vhost-type", ""},
+		{"vhost-authn-level", "-1"},
+	} {
+		_, errInvalid := NewVHostOptions(map[string]string{prefix + "/" + c.annotation: c.value}, prefix, false)
+		if errInvalid == nil || !regexp.MustCompile("^Invalid "+c.annotation).MatchString(errInvalid.Error()) {
+			t.Errorf("Expected invalid %s %q error, got %q", c.annotation, c.value, errInvalid)
+		}
+	}
+	if options, err := NewVHostOptions(map[string]string{prefix + "/vhost-port": "-1"}, prefix, true); err != nil || options.Port != -1 {
+		t.Errorf("Expected vhost-port -1, got %d, %v", options.Port, err)
+	}
+
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	vhost := NewVHost("test42.example.org", DefaultLocationRules, DefaultExportedHeaders)
+	vhost.Options = options
+	config.AddVHosts(map[string]*VHost{
+		"test42.example.org": vhost,
+		"test45.example.org": NewVHost("test45.example.org", DefaultLocationRules, DefaultExportedHeaders),
+	})
+	errSave2 := config.Save()
+	if errSave2 != nil {
+		t.Errorf("%s", errSave2)
+	}
+	lmConf2, err2 := fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-2.js")
+	if err2 != nil {
+		t.Errorf("%s", err2)
+	}
+	for _, re := range []*regexp.Regexp{
+		regexp.MustCompile(`"vhostOptions": {\s*"test42.example.org": {\s*"vhostAliases": "test43.example.org test44.example.org",\s*"vhostAuthnLevel": 3,\s*"vhostHttps": 1,\s*"vhostMaintenance": 0,\s*"vhostPort": 8443,\s*"vhostType": "DevOps"\s*},`),
+		regexp.MustCompile(`"test45.example.org": {\s*"vhostAliases": "",\s*"vhostHttps": -1,\s*"vhostMaintenance": 0,\s*"vhostPort": -1,\s*"vhostType": "Main"\s*}\s*}`),
+	} {
+		if !re.Match(lmConf2) {
+			t.Errorf("lmConf-2.js to match %s\n%s", re, lmConf2)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	prefix := "kubernetes-controller.lemonldap-ng.org"
+	tlsOptions, err := NewVHostOptions(map[string]string{}, prefix, true)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if tlsOptions.HTTPS != 1 || tlsOptions.Port != 443 {
+		t.Errorf("Expected TLS options to be https on port 443, got %+v", tlsOptions)
+	}
+	overriddenOptions, err := NewVHostOptions(map[string]string{prefix + "/vhost-port": "8443"}, prefix, true)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	tlsVHost := NewVHost("test42.example.org", DefaultLocationRules, DefaultExportedHeaders)
+	tlsVHost.Options = tlsOptions
+	tlsVHost.TLS = true
+	tlsVHost.TLSSecret = "default/test42-tls"
+	overriddenVHost := NewVHost("test43.example.org", DefaultLocationRules, DefaultExportedHeaders)
+	overriddenVHost.Options = overriddenOptions
+	overriddenVHost.TLS = true
+	plainVHost := NewVHost("test44.example.org", DefaultLocationRules, DefaultExportedHeaders)
+	for vhost, expected := range map[*VHost]string{
+		tlsVHost:        "https://test42.example.org/",
+		overriddenVHost: "https://test43.example.org:8443/",
+		plainVHost:      "http://test44.example.org/",
+	} {
+		if vhost.URL() != expected {
+			t.Errorf("Expected URL %s, got %s", expected, vhost.URL())
+		}
+	}
+
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	config.AddVHosts(map[string]*VHost{
+		"test42.example.org": tlsVHost,
+		"test43.example.org": overriddenVHost,
+	})
+	errSave2 := config.Save()
+	if errSave2 != nil {
+		t.Errorf("%s", errSave2)
+	}
+	lmConf2, err2 := fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-2.js")
+	if err2 != nil {
+		t.Errorf("%s", err2)
+	}
+	if re := regexp.MustCompile(`"securedCookie": 1,`); !re.Match(lmConf2) {
+		t.Errorf("lmConf-2.js to match %s\n%s", re, lmConf2)
+	}
+
+	config.AddVHosts(map[string]*VHost{
+		"test44.example.org": plainVHost,
+	})
+	errSave3 := config.Save()
+	if errSave3 != nil {
+		t.Errorf("%s", errSave3)
+	}
+	lmConf3, err3 := fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-3.js")
+	if err3 != nil {
+		t.Errorf("%s", err3)
+	}
+	if re := regexp.MustCompile(`"securedCookie"`); re.Match(lmConf3) {
+		t.Errorf("lmConf-3.js not to match %s\n%s", re, lmConf3)
+	}
+
+	// securedCookie of the base configuration is kept
+	base, err := fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-1.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	fs = fakefs.NewFilesystem()
+	fs.WriteFile("/var/lib/lemonldap-ng/conf/lmConf-1.js", bytes.Replace(base, []byte("{"), []byte(`{"securedCookie": 2,`), 1), 0644)
+	config = NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	config.AddVHosts(map[string]*VHost{
+		"test42.example.org": tlsVHost,
+	})
+	if err = config.Save(); err != nil {
+		t.Errorf("%s", err)
+	}
+	lmConf2, err = fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-2.js")
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if re := regexp.MustCompile(`"securedCookie": 2,`); !re.Match(lmConf2) {
+		t.Errorf("lmConf-2.js to match %s\n%s", re, lmConf2)
+	}
+}
+
+func TestVHostOwners(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	api := NewVHost("test42.example.org", map[string]string{"(?#!scope 9995 0)(?=^/api)": "inGroup('api')"}, DefaultExportedHeaders)
+	api.Owner = "default/api"
+	web := NewVHost("test42.example.org", map[string]string{"(?#!scope 9995 0)(?=^/web)": "accept"}, map[string]string{"Auth-User": "$mail"})
+	web.Owner = "default/web"
+	other := NewVHost("test42.example.org", map[string]string{"default": "accept"}, DefaultExportedHeaders)
+	other.Owner = "other/app"
+	config.AddVHosts(map[string]*VHost{"test42.example.org": api})
+	config.AddVHosts(map[string]*VHost{"test42.example.org": web})
+	config.AddVHosts(map[string]*VHost{"test42.example.org": other})
+	errSave1 := config.Save()
+	if errSave1 != nil {
+		t.Errorf("%s", errSave1)
+	}
+	lmConf2, err2 := fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-2.js")
+	if err2 != nil {
+		t.Errorf("%s", err2)
+	}
+	// Without sharing, the first owner is kept
+	if re := regexp.MustCompile(`"locationRules": {\s*"test42.example.org": {\s*"\(\?#!scope 9995 0\)\(\?=\^/api\)": "inGroup\('api'\)"\s*}\s*},`); !re.Match(lmConf2) {
+		t.Errorf("lmConf-2.js to match %s\n%s", re, lmConf2)
+	}
+	expected := map[string]string{
+		"default/web": "Virtual host test42.example.org is already defined by default/api, ignoring it",
+		"other/app":   "Virtual host test42.example.org is already defined by default/api, ignoring it",
+	}
+	conflicts := config.Conflicts()
+	if len(conflicts) != len(expected) {
+		t.Errorf("Expected %d conflicts, got %v", len(expected), conflicts)
+	}
+	for _, conflict := range conflicts {
+		if conflict.Reason != ReasonVHostConflict || conflict.Message != expected[conflict.Owner] {
+			t.Errorf("Unexpected conflict %+v", conflict)
+		}
+	}
+
+	// Shared virtual hosts are merged
+	api.Shared = true
+	web.Shared = true
+	config.DeleteVHosts(map[string]*VHost{"test42.example.org": other})
+	config.AddVHosts(map[string]*VHost{"test42.example.org": api})
+	config.AddVHosts(map[string]*VHost{"test42.example.org": web})
+	errSave2 := config.Save()
+	if errSave2 != nil {
+		t.Errorf("%s", errSave2)
+	}
+	lmConf3, err3 := fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-3.js")
+	if err3 != nil {
+		t.Errorf("%s", err3)
+	}
+	if conflicts = config.Conflicts(); len(conflicts) != 0 {
+		t.Errorf("Expected no conflict, got %v", conflicts)
+	}
+	// URIs outside of the scoped rules are denied
+	conf, err := config.Load("lmConf-3.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if rule := conf["locationRules"].(map[string]interface{})["test42.example.org"].(map[string]interface{})["default"]; rule != ScopedDefaultRule {
+		t.Errorf("Expected default rule %s, got %v", ScopedDefaultRule, rule)
+	}
+	for _, re := range []*regexp.Regexp{
+		regexp.MustCompile(`"exportedHeaders": {\s*"test42.example.org": {\s*"Auth-User": "\$uid"\s*}\s*},`),
+		regexp.MustCompile(`"locationRules": {\s*"test42.example.org": {\s*"\(\?#!scope 9995 0\)\(\?=\^/api\)": "inGroup\('api'\)",\s*"\(\?#!scope 9995 0\)\(\?=\^/web\)": "accept",\s*"default": "deny"\s*}\s*},`),
+	} {
+		if !re.Match(lmConf3) {
+			t.Errorf("lmConf-3.js to match %s\n%s", re, lmConf3)
+		}
+	}
+
+	config.DeleteVHosts(map[string]*VHost{"test42.example.org": web})
+	errSave3 := config.Save()
+	if errSave3 != nil {
+		t.Errorf("%s", errSave3)
+	}
+	lmConf4, err4 := fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-4.js")
+	if err4 != nil {
+		t.Errorf("%s", err4)
+	}
+	if re := regexp.MustCompile(`"locationRules": {\s*"test42.example.org": {\s*"\(\?#!scope 9995 0\)\(\?=\^/api\)": "inGroup\('api'\)"\s*}\s*},`); !re.Match(lmConf4) {
+		t.Errorf("lmConf-4.js to match %s\n%s", re, lmConf4)
+	}
+}
+
+func TestDefaultVHostPolicy(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	config.SetDefaultVHostPolicy(DefaultVHostFirst)
+	first := NewVHost(DefaultServerName, map[string]string{"default": "accept"}, DefaultExportedHeaders)
+	first.Owner = "a/first"
+	second := NewVHost(DefaultServerName, map[string]string{"^/admin/": "deny", "default": "skip"}, DefaultExportedHeaders)
+	second.Owner = "b/second"
+	config.AddVHosts(map[string]*VHost{DefaultServerName: second})
+	config.AddVHosts(map[string]*VHost{DefaultServerName: first})
+	errSave2 := config.Save()
+	if errSave2 != nil {
+		t.Errorf("%s", errSave2)
+	}
+	lmConf2, err2 := fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-2.js")
+	if err2 != nil {
+		t.Errorf("%s", err2)
+	}
+	if re := regexp.MustCompile(`"locationRules": {\s*"default": {\s*"default": "accept"\s*}\s*},`); !re.Match(lmConf2) {
+		t.Errorf("lmConf-2.js to match %s\n%s", re, lmConf2)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FormReplay defines a LemonLDAP::NG form replay (post) target
+type FormReplay struct {
+	// URL is the path of the page holding the form
+	URL string `yaml:"url"`
+	// Target is the path the form is posted to, the form action when empty
+	Target       string `yaml:"target,omitempty"`
+	FormSelector string `yaml:"formSelector,omitempty"`
+	// Fields maps form field names to session attribute expressions
+	Fields    map[string]string `yaml:"fields"`
+	JQueryURL string            `yaml:"jqueryUrl,omitempty"`
+	Buttons   string            `yaml:"buttons,omitempty"`
+}
+
+// ParseFormReplays parses a list of FormReplay
+func ParseFormReplays(in string) ([]FormReplay, error) {
+	var formReplays []FormReplay
+	if err := yaml.UnmarshalStrict([]byte(in), &formReplays); err != nil {
+		return nil, err
+	}
+	urls := make(map[string]bool)
+	for i := range formReplays {
+		f := &formReplays[i]
+		if !strings.HasPrefix(f.URL, "/") {
+			return nil, fmt.Errorf("Form replay %d url %q should start with /", i, f.URL)
+		}
+		if urls[f.URL] {
+			return nil, fmt.Errorf("Form replay %d url %s is a duplicate", i, f.URL)
+		}
+		urls[f.URL] = true
+		if f.Target != "" && !strings.HasPrefix(f.Target, "/") {
+			return nil, fmt.Errorf("Form replay %s target %q should start with /", f.URL, f.Target)
+		}
+		if len(f.Fields) == 0 {
+			return nil, fmt.Errorf("Form replay %s has no fields", f.URL)
+		}
+		for name, value := range f.Fields {
+			if name == "" || value == "" {
+				return nil, fmt.Errorf("Form replay %s has an empty field name or value", f.URL)
+			}
+		}
+		if f.FormSelector == "" {
+			f.FormSelector = "form"
+		}
+	}
+	return formReplays, nil
+}
+
+// toConfig returns the form replay as a LemonLDAP::NG post entry
+func (f FormReplay) toConfig() map[string]interface{} {
+	names := []string{}
+	for name := range f.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := make([][]string, len(names))
+	for i, name := range names {
+		vars[i] = []string{name, f.Fields[name]}
+	}
+	return map[string]interface{}{
+		"target":       f.Target,
+		"formSelector": f.FormSelector,
+		"vars":         vars,
+		"jqueryUrl":    f.JQueryURL,
+		"buttons":      f.Buttons,
+	}
+}
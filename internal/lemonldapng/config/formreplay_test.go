@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	fakefs "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem/fake"
+)
+
+func TestParseFormReplays(t *testing.T) {
+	formReplays, err := ParseFormReplays(`
+- url: /login.php
+  target: /auth.php
+  fields:
+    user: $uid
+    password: $_password
+  jqueryUrl: /js/jquery.js
+  buttons: "#submit"
+- url: /admin/
+  formSelector: "#login"
+  fields: {login: $mail}
+`)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := []FormReplay{
+		{
+			URL:          "/login.php",
+			Target:       "/auth.php",
+			FormSelector: "form",
+			Fields:       map[string]string{"user": "$uid", "password": "$_password"},
+			JQueryURL:    "/js/jquery.js",
+			Buttons:      "#submit",
+		},
+		{
+			URL:          "/admin/",
+			FormSelector: "#login",
+			Fields:       map[string]string{"login": "$mail"},
+		},
+	}
+	if !reflect.DeepEqual(formReplays, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, formReplays)
+	}
+
+	for in, expectedErr := range map[string]string{
+		`[{url: login.php, fields: {a: $uid}}]`:                        `Form replay 0 url "login.php" should start with /`,
+		`[{url: /a, fields: {a: $uid}}, {url: /a, fields: {a: $uid}}]`: `Form replay 1 url /a is a duplicate`,
+		`[{url: /a, target: b, fields: {a: $uid}}]`:                    `Form replay /a target "b" should start with /`,
+		`[{url: /a}]`:                  `Form replay /a has no fields`,
+		`[{url: /a, fields: {a: ""}}]`: `Form replay /a has an empty field name or value`,
+	} {
+		_, err = ParseFormReplays(in)
+		if err == nil || err.Error() != expectedErr {
+			t.Errorf("Expected error %q for %s, got %v", expectedErr, in, err)
+		}
+	}
+	if _, err = ParseFormReplays(`[{url: /a, fields: {a: $uid}, method: POST}]`); err == nil {
+		t.Errorf("Expected unknown field error")
+	}
+}
+
+func TestSaveFormReplays(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	vhost := NewVHost("test42.example.org", DefaultLocationRules, DefaultExportedHeaders)
+	vhost.FormReplays = []FormReplay{
+		{
+			URL:          "/login.php",
+			FormSelector: "form",
+			Fields:       map[string]string{"user": "$uid", "password": "$_password"},
+		},
+	}
+	config.AddVHosts(map[string]*VHost{"test42.example.org": vhost})
+	errSave2 := config.Save()
+	if errSave2 != nil {
+		t.Errorf("%s", errSave2)
+	}
+	lmConf2, err2 := fs.ReadFile("/var/lib/lemonldap-ng/conf/lmConf-2.js")
+	if err2 != nil {
+		t.Errorf("%s", err2)
+	}
+	if re := regexp.MustCompile(`"post": {\s*"test42.example.org": {\s*"/login.php": {\s*"buttons": "",\s*"formSelector": "form",\s*"jqueryUrl": "",\s*"target": "",\s*"vars": \[\s*\[\s*"password",\s*"\$_password"\s*\],\s*\[\s*"user",\s*"\$uid"\s*\]\s*\]\s*}\s*}\s*},`); !re.Match(lmConf2) {
+		t.Errorf("lmConf-2.js to match %s\n%s", re, lmConf2)
+	}
+}
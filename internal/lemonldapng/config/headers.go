@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultDeniedHeaderAttributes are the session attributes which can not be
+// exported in headers by default
+var DefaultDeniedHeaderAttributes = []string{"_password"}
+
+var (
+	// headerNameRE matches an RFC 7230 token
+	headerNameRE    = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+	attributeNameRE = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+	// variableRE matches the session attributes used in an expression
+	variableRE = regexp.MustCompile(`\$\{?([A-Za-z_]\w*)`)
+)
+
+// CompileExportedHeaders validates exported headers and compiles their
+// helpers: "base64:<expression>", "join:<separator>:<attribute>,..." and
+// "json:<attribute>,...". Header names should be RFC 7230 tokens, unique
+// regardless of case, and values should not use deniedAttributes
+func CompileExportedHeaders(exportedHeaders map[string]string, deniedAttributes []string) (map[string]string, error) {
+	denied := make(map[string]bool)
+	for _, attribute := range deniedAttributes {
+		denied[attribute] = true
+	}
+	names := []string{}
+	for name := range exportedHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	compiled := make(map[string]string)
+	lowerNames := make(map[string]string)
+	for _, name := range names {
+		if !headerNameRE.MatchString(name) {
+			return nil, fmt.Errorf("Invalid header name %q", name)
+		}
+		if other, ok := lowerNames[strings.ToLower(name)]; ok {
+			return nil, fmt.Errorf("Header %s is a duplicate of %s", name, other)
+		}
+		lowerNames[strings.ToLower(name)] = name
+		value, err := compileHeaderValue(exportedHeaders[name])
+		if err != nil {
+			return nil, fmt.Errorf("Header %s: %s", name, err)
+		}
+		for _, m := range variableRE.FindAllStringSubmatch(value, -1) {
+			if denied[m[1]] {
+				return nil, fmt.Errorf("Header %s: session attribute %s is not allowed", name, m[1])
+			}
+		}
+		compiled[name] = value
+	}
+	return compiled, nil
+}
+
+// compileHeaderValue compiles the helper of a header value, if any
+func compileHeaderValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "base64:"):
+		expression := strings.TrimPrefix(value, "base64:")
+		if expression == "" {
+			return "", fmt.Errorf("base64: needs an expression")
+		}
+		return "encode_base64(" + expression + ", '')", nil
+	case strings.HasPrefix(value, "join:"):
+		parts := strings.SplitN(strings.TrimPrefix(value, "join:"), ":", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("join: should be join:<separator>:<attribute>,...")
+		}
+		variables, err := helperVariables(parts[1])
+		if err != nil {
+			return "", err
+		}
+		return "join(" + perlQuote(parts[0]) + ", " + strings.Join(variables, ", ") + ")", nil
+	case strings.HasPrefix(value, "json:"):
+		attributes := strings.TrimPrefix(value, "json:")
+		variables, err := helperVariables(attributes)
+		if err != nil {
+			return "", err
+		}
+		pairs := make([]string, len(variables))
+		for i, attribute := range strings.Split(attributes, ",") {
+			pairs[i] = "[" + perlQuote(strings.TrimSpace(attribute)) + ", " + variables[i] + "]"
+		}
+		return `'{' . join(',', map { my $v = $_->[1] // ''; $v =~ s/(["\\])/\\$1/g; $v =~ s/([\x00-\x1f])/sprintf('\\u%04x', ord($1))/ge; '"' . $_->[0] . '":"' . $v . '"' } (` + strings.Join(pairs, ", ") + `)) . '}'`, nil
+	}
+	return value, nil
+}
+
+// helperVariables returns the variables of a comma separated list of
+// session attributes
+func helperVariables(attributes string) ([]string, error) {
+	variables := []string{}
+	for _, attribute := range strings.Split(attributes, ",") {
+		attribute = strings.TrimSpace(attribute)
+		if !attributeNameRE.MatchString(attribute) {
+			return nil, fmt.Errorf("invalid session attribute %q", attribute)
+		}
+		variables = append(variables, "$"+attribute)
+	}
+	return variables, nil
+}
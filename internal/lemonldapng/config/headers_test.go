@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestCompileExportedHeaders(t *testing.T) {
+	compiled, err := CompileExportedHeaders(map[string]string{
+		"Auth-User":    "$uid",
+		"Auth-Base64":  "base64:$givenName.' '.$sn",
+		"Auth-Groups":  "join:, :groups",
+		"Auth-Names":   "join:|:givenName, sn",
+		"Auth-Profile": "json:uid,mail",
+	}, DefaultDeniedHeaderAttributes)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := map[string]string{
+		"Auth-User":    "$uid",
+		"Auth-Base64":  "encode_base64($givenName.' '.$sn, '')",
+		"Auth-Groups":  "join(', ', $groups)",
+		"Auth-Names":   "join('|', $givenName, $sn)",
+		"Auth-Profile": `'{' . join(',', map { my $v = $_->[1] // ''; $v =~ s/(["\\])/\\$1/g; $v =~ s/([\x00-\x1f])/sprintf('\\u%04x', ord($1))/ge; '"' . $_->[0] . '":"' . $v . '"' } (['uid', $uid], ['mail', $mail])) . '}'`,
+	}
+	if !reflect.DeepEqual(compiled, expected) {
+		t.Errorf("Expected %v, got %v", expected, compiled)
+	}
+}
+
+func TestCompileExportedHeadersJSON(t *testing.T) {
+	perl, err := exec.LookPath("perl")
+	if err != nil {
+		t.Skip("perl is required to evaluate the json: helper")
+	}
+	compiled, err := CompileExportedHeaders(map[string]string{"Auth-Profile": "json:uid,mail"}, nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	uid := "bart\"s \\ \n\t\x01"
+	script := "my $uid = $ARGV[0]; my $mail; print " + compiled["Auth-Profile"]
+	out, err := exec.Command(perl, "-e", script, uid).Output()
+	if err != nil {
+		t.Errorf("Unable to evaluate %s: %s", compiled["Auth-Profile"], err)
+		return
+	}
+	var profile map[string]string
+	if err = json.Unmarshal(out, &profile); err != nil {
+		t.Errorf("Invalid JSON %s: %s", out, err)
+		return
+	}
+	if profile["uid"] != uid || profile["mail"] != "" {
+		t.Errorf("Expected uid %q and empty mail, got %v", uid, profile)
+	}
+}
+
+func TestCompileExportedHeadersErrors(t *testing.T) {
+	for _, c := range []struct {
+		headers  map[string]string
+		expected string
+	}{
+		{map[string]string{"Auth-User ": "$uid"}, `Invalid header name "Auth-User "`},
+		{map[string]string{"Auth:User": "$uid"}, `Invalid header name "Auth:User"`},
+		{map[string]string{"Auth-User": "$uid", "auth-user": "$mail"}, "Header auth-user is a duplicate of Auth-User"},
+		{map[string]string{"Auth-Password": "$_password"}, "Header Auth-Password: session attribute _password is not allowed"},
+		{map[string]string{"Auth-Password": "base64:${_password}"}, "Header Auth-Password: session attribute _password is not allowed"},
+		{map[string]string{"Auth-Password": "json:uid,_password"}, "Header Auth-Password: session attribute _password is not allowed"},
+		{map[string]string{"Auth-Base64": "base64:"}, "Header Auth-Base64: base64: needs an expression"},
+		{map[string]string{"Auth-Join": "join:uid"}, "Header Auth-Join: join: should be join:<separator>:<attribute>,..."},
+		{map[string]string{"Auth-Json": "json:$uid"}, `Header Auth-Json: invalid session attribute "$uid"`},
+	} {
+		_, err := CompileExportedHeaders(c.headers, DefaultDeniedHeaderAttributes)
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("Expected error %q for %v, got %v", c.expected, c.headers, err)
+		}
+	}
+
+	if _, err := CompileExportedHeaders(map[string]string{"Auth-Password": "$_password"}, nil); err != nil {
+		t.Errorf("Expected no error with an empty deny-list, got %s", err)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultServerName is the server name of host-less Ingress rules
+const DefaultServerName = "default"
+
+// Policies for the default virtual host, when several owners define it
+const (
+	// DefaultVHostMerge merges the rules of all owners
+	DefaultVHostMerge = "merge"
+	// DefaultVHostFirst keeps the first owner only, in name order
+	DefaultVHostFirst = "first"
+	// DefaultVHostDeny ignores host-less Ingress rules
+	DefaultVHostDeny = "deny"
+)
+
+var serverNameRE = regexp.MustCompile(`^(\*\.)?[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// ParseDefaultVHostPolicy validates a default virtual host policy
+func ParseDefaultVHostPolicy(policy string) (string, error) {
+	switch policy {
+	case DefaultVHostMerge, DefaultVHostFirst, DefaultVHostDeny:
+		return policy, nil
+	}
+	return "", fmt.Errorf("Invalid default vhost policy %q: should be %s, %s or %s", policy, DefaultVHostMerge, DefaultVHostFirst, DefaultVHostDeny)
+}
+
+// NormalizeServerName returns the server name of an Ingress host: empty and
+// "*" hosts are DefaultServerName, and wildcards are only allowed as the
+// first label, like "*.example.org"
+func NormalizeServerName(host string) (string, error) {
+	if host == "" || host == "*" {
+		return DefaultServerName, nil
+	}
+	serverName := strings.ToLower(host)
+	if !serverNameRE.MatchString(serverName) {
+		return "", fmt.Errorf("Invalid host %q: should be a DNS name, optionally starting with *.", host)
+	}
+	return serverName, nil
+}
+
+// IsWildcard returns true when the server name is a wildcard
+func IsWildcard(serverName string) bool {
+	return strings.HasPrefix(serverName, "*.")
+}
+
+// HostMatches returns true when host matches pattern, either exactly or as a
+// wildcard covering exactly one label
+func HostMatches(pattern, host string) bool {
+	if pattern == host {
+		return true
+	}
+	if !IsWildcard(pattern) || IsWildcard(host) {
+		return false
+	}
+	i := strings.Index(host, ".")
+	return i > 0 && host[i:] == pattern[1:]
+}
+
+// MatchHost returns the value of the most specific pattern matching host:
+// the exact host, else a matching wildcard
+func MatchHost(patterns map[string]string, host string) (string, bool) {
+	if value, ok := patterns[host]; ok {
+		return value, true
+	}
+	for pattern, value := range patterns {
+		if HostMatches(pattern, host) {
+			return value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestNormalizeServerName(t *testing.T) {
+	for host, expected := range map[string]string{
+		"":                  "default",
+		"*":                 "default",
+		"App.Example.org":   "app.example.org",
+		"*.example.org":     "*.example.org",
+		"app-1.example.org": "app-1.example.org",
+	} {
+		serverName, err := NormalizeServerName(host)
+		if err != nil || serverName != expected {
+			t.Errorf("Expected %q for %q, got %q (%v)", expected, host, serverName, err)
+		}
+	}
+	for _, host := range []string{"app*.example.org", "*.*.example.org", "app.*.example.org", "-app.example.org", "app..example.org"} {
+		if _, err := NormalizeServerName(host); err == nil {
+			t.Errorf("Expected invalid host error for %q", host)
+		}
+	}
+}
+
+func TestHostMatches(t *testing.T) {
+	for _, c := range []struct {
+		pattern, host string
+		match         bool
+	}{
+		{"app.example.org", "app.example.org", true},
+		{"*.example.org", "app.example.org", true},
+		{"*.example.org", "*.example.org", true},
+		{"*.example.org", "a.b.example.org", false},
+		{"*.example.org", "example.org", false},
+		{"*.example.org", "*.sub.example.org", false},
+		{"app.example.org", "*.example.org", false},
+	} {
+		if HostMatches(c.pattern, c.host) != c.match {
+			t.Errorf("Expected %s match of %s to be %v", c.pattern, c.host, c.match)
+		}
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	patterns := map[string]string{
+		"*.example.org":   "default/wildcard-tls",
+		"app.example.org": "default/app-tls",
+	}
+	for host, expected := range map[string]string{
+		"app.example.org":   "default/app-tls",
+		"other.example.org": "default/wildcard-tls",
+		"*.example.org":     "default/wildcard-tls",
+	} {
+		if value, ok := MatchHost(patterns, host); !ok || value != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, host, value)
+		}
+	}
+	if _, ok := MatchHost(patterns, "example.org"); ok {
+		t.Errorf("Expected no match for example.org")
+	}
+	if _, err := ParseDefaultVHostPolicy("random"); err == nil {
+		t.Errorf("Expected invalid policy error")
+	}
+}
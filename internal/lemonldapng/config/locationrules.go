@@ -0,0 +1,85 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LocationRule defines an ordered LemonLDAP::NG location rule
+type LocationRule struct {
+	// Path is the path regex, or "default"
+	Path    string `yaml:"path"`
+	Rule    string `yaml:"rule"`
+	Comment string `yaml:"comment,omitempty"`
+}
+
+// ParseLocationRules parses location rules, either as a map of path regex to
+// rule, or as an ordered list of LocationRule
+func ParseLocationRules(in string) (map[string]string, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal([]byte(in), &raw); err != nil {
+		return nil, err
+	}
+	if _, ok := raw.([]interface{}); ok {
+		var list []LocationRule
+		if err := yaml.UnmarshalStrict([]byte(in), &list); err != nil {
+			return nil, err
+		}
+		return OrderLocationRules(list)
+	}
+	locationRules := make(map[string]string)
+	if err := yaml.Unmarshal([]byte(in), &locationRules); err != nil {
+		return nil, err
+	}
+	return locationRules, nil
+}
+
+// OrderLocationRules returns location rules whose keys are prefixed by an
+// ordering comment, as LemonLDAP::NG applies rules in keys order
+func OrderLocationRules(list []LocationRule) (map[string]string, error) {
+	locationRules := make(map[string]string)
+	width := len(strconv.Itoa(len(list)))
+	for i, r := range list {
+		if r.Path == "" {
+			return nil, fmt.Errorf("Location rule %d has no path", i)
+		}
+		if r.Rule == "" {
+			return nil, fmt.Errorf("Location rule %d (%s) has no rule", i, r.Path)
+		}
+		if strings.Contains(r.Comment, ")") {
+			return nil, fmt.Errorf("Location rule %d (%s) comment should not contain ')'", i, r.Path)
+		}
+		key := r.Path
+		if key != "default" {
+			comment := fmt.Sprintf("%0*d", width, i)
+			if r.Comment != "" {
+				comment += " " + r.Comment
+			}
+			key = "(?#" + comment + ")" + r.Path
+		}
+		if _, ok := locationRules[key]; ok {
+			return nil, fmt.Errorf("Location rule %d is a duplicate of %s", i, key)
+		}
+		locationRules[key] = r.Rule
+	}
+	return locationRules, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLocationRulesMap(t *testing.T) {
+	locationRules, err := ParseLocationRules(`{"^/admin/": "$uid eq \"bart.simpson\"", "default": "accept"}`)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := map[string]string{
+		"^/admin/": `$uid eq "bart.simpson"`,
+		"default":  "accept",
+	}
+	if !reflect.DeepEqual(locationRules, expected) {
+		t.Errorf("Expected %v, got %v", expected, locationRules)
+	}
+}
+
+func TestParseLocationRulesList(t *testing.T) {
+	locationRules, err := ParseLocationRules(`
+- path: ^/admin/users/
+  rule: $uid eq "bart.simpson"
+  comment: user admins
+- path: ^/admin/
+  rule: inGroup("admins")
+- path: default
+  rule: accept
+`)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := map[string]string{
+		"(?#0 user admins)^/admin/users/": `$uid eq "bart.simpson"`,
+		"(?#1)^/admin/":                   `inGroup("admins")`,
+		"default":                         "accept",
+	}
+	if !reflect.DeepEqual(locationRules, expected) {
+		t.Errorf("Expected %v, got %v", expected, locationRules)
+	}
+
+	list := make([]LocationRule, 11)
+	for i := range list {
+		list[i] = LocationRule{Path: "^/", Rule: "accept"}
+	}
+	locationRules, err = OrderLocationRules(list)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	for _, key := range []string{"(?#00)^/", "(?#10)^/"} {
+		if _, ok := locationRules[key]; !ok {
+			t.Errorf("Expected key %s in %v", key, locationRules)
+		}
+	}
+}
+
+func TestParseLocationRulesErrors(t *testing.T) {
+	for in, expected := range map[string]string{
+		`{"default": [1]}`:                                   "yaml: unmarshal errors:\n  line 1: cannot unmarshal !!seq into string",
+		`[{"path": "^/", "expression": "accept"}]`:           "yaml: unmarshal errors:\n  line 1: field expression not found in type config.LocationRule",
+		`[{"rule": "accept"}]`:                               "Location rule 0 has no path",
+		`[{"path": "^/"}]`:                                   "Location rule 0 (^/) has no rule",
+		`[{"path": "^/", "rule": "accept", "comment": ")"}]`: "Location rule 0 (^/) comment should not contain ')'",
+		`[{"path": "default", "rule": "accept"}, {"path": "default", "rule": "deny"}]`: "Location rule 1 is a duplicate of default",
+	} {
+		_, err := ParseLocationRules(in)
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q for %s, got %q", expected, in, err)
+		}
+	}
+}
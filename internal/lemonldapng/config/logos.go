@@ -0,0 +1,167 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem"
+)
+
+// Logos of the application-logo annotation
+const (
+	// DefaultLogo is the logo of applications without application-logo
+	DefaultLogo = "gear.png"
+	// LogoConfigMapPrefix prefixes a logo from a ConfigMap: configmap:<name>/<key>
+	LogoConfigMapPrefix = "configmap:"
+	// LogoFavicon is a logo fetched from the backend Service /favicon.ico
+	LogoFavicon = "favicon"
+)
+
+const maxLogoSize = 1 << 20
+
+// LogoFetchPeriod is the period after which fetched logos are fetched again
+const LogoFetchPeriod = time.Hour
+
+var invalidLogoChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// LogoFetchedHandler is called when a logo is fetched for the first time
+type LogoFetchedHandler func(url string)
+
+// fetchedLogo is a logo fetched from an URL
+type fetchedLogo struct {
+	fileName  string
+	fetchedAt time.Time
+}
+
+// Logos writes custom application logos in the portal skin directory
+type Logos struct {
+	sync.Mutex
+	fs     filesystem.Filesystem
+	dir    string
+	client *http.Client
+	// fetched are the logos already fetched, by URL
+	fetched map[string]fetchedLogo
+	// fetching are the URLs being fetched, and fetchErrs the errors of the
+	// last fetch of URLs not fetched yet
+	fetching  map[string]bool
+	fetchErrs map[string]error
+	// fetchedHandler is called when a logo is fetched for the first time
+	fetchedHandler LogoFetchedHandler
+}
+
+// NewLogos creates a new logos writer to the dir directory
+func NewLogos(fs filesystem.Filesystem, dir string) *Logos {
+	return &Logos{
+		fs:        fs,
+		dir:       dir,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		fetched:   make(map[string]fetchedLogo),
+		fetching:  make(map[string]bool),
+		fetchErrs: make(map[string]error),
+	}
+}
+
+// SetFetchedHandler sets the handler of logos fetched for the first time
+func (l *Logos) SetFetchedHandler(handler LogoFetchedHandler) {
+	l.Lock()
+	defer l.Unlock()
+	l.fetchedHandler = handler
+}
+
+// Write writes a logo, unless unchanged, and returns its file name
+func (l *Logos) Write(name string, content []byte) (string, error) {
+	name = invalidLogoChars.ReplaceAllString(name, "_")
+	path := l.dir + "/" + name
+	if existing, err := l.fs.ReadFile(path); err == nil && bytes.Equal(existing, content) {
+		return name, nil
+	}
+	if err := l.fs.WriteFile(path, content, 0644); err != nil {
+		return "", fmt.Errorf("Unable to write logo %s: %s", path, err)
+	}
+	return name, nil
+}
+
+// Fetch returns the file name of the logo fetched from url. Logos are fetched
+// in the background, and again after LogoFetchPeriod: an error is returned
+// until the first fetch succeeds, then the fetched handler is called
+func (l *Logos) Fetch(name, url string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	logo, ok := l.fetched[url]
+	if (!ok || time.Since(logo.fetchedAt) > LogoFetchPeriod) && !l.fetching[url] {
+		l.fetching[url] = true
+		go l.fetch(name, url)
+	}
+	if ok {
+		return logo.fileName, nil
+	}
+	if err, failed := l.fetchErrs[url]; failed {
+		return "", err
+	}
+	return "", fmt.Errorf("logo %s is being fetched", url)
+}
+
+// fetch fetches a logo from url and writes it. A logo already fetched is
+// kept on failure
+func (l *Logos) fetch(name, url string) {
+	fileName, err := l.download(name, url)
+	l.Lock()
+	delete(l.fetching, url)
+	_, refetched := l.fetched[url]
+	if err != nil {
+		if !refetched {
+			l.fetchErrs[url] = err
+		}
+		l.Unlock()
+		return
+	}
+	l.fetched[url] = fetchedLogo{fileName: fileName, fetchedAt: time.Now()}
+	delete(l.fetchErrs, url)
+	handler := l.fetchedHandler
+	l.Unlock()
+	if !refetched && handler != nil {
+		handler(url)
+	}
+}
+
+// download fetches a logo from url, writes it and returns its file name
+func (l *Logos) download(name, url string) (string, error) {
+	resp, err := l.client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("Unable to fetch logo %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unable to fetch logo %s: %s", url, resp.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxLogoSize+1))
+	if err != nil {
+		return "", fmt.Errorf("Unable to fetch logo %s: %s", url, err)
+	}
+	if len(content) > maxLogoSize {
+		return "", fmt.Errorf("Unable to fetch logo %s: larger than %d bytes", url, maxLogoSize)
+	}
+	return l.Write(name, content)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fakefs "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem/fake"
+)
+
+func TestLogosWrite(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	logos := NewLogos(fs, "/var/lib/lemonldap-ng/conf")
+	name, err := logos.Write("ns1_logos_my app.png", []byte("PNG"))
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if name != "ns1_logos_my_app.png" {
+		t.Errorf("Expected sanitized name, got %s", name)
+	}
+	if content, err := fs.ReadFile("/var/lib/lemonldap-ng/conf/ns1_logos_my_app.png"); err != nil || string(content) != "PNG" {
+		t.Errorf("Expected logo content, got %q, %v", content, err)
+	}
+	if _, err = NewLogos(fs, "/nonexistent").Write("logo.png", []byte("PNG")); err == nil {
+		t.Errorf("Expected write error")
+	}
+}
+
+// waitFetch waits for the background fetch of url
+func waitFetch(logos *Logos, url string) {
+	for i := 0; i < 500; i++ {
+		logos.Lock()
+		fetching := logos.fetching[url]
+		logos.Unlock()
+		if !fetching {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLogosFetch(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/favicon.ico":
+			w.Write([]byte("ICO"))
+		case "/large.ico":
+			w.Write(bytes.Repeat([]byte("I"), maxLogoSize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fs := fakefs.NewFilesystem()
+	logos := NewLogos(fs, "/var/lib/lemonldap-ng/conf")
+	fetched := make(chan string, 1)
+	logos.SetFetchedHandler(func(url string) {
+		fetched <- url
+	})
+	url := server.URL + "/favicon.ico"
+	if _, err := logos.Fetch("ns1_app_favicon.ico", url); err == nil {
+		t.Errorf("Expected an error until the logo is fetched")
+	}
+	select {
+	case fetchedURL := <-fetched:
+		if fetchedURL != url {
+			t.Errorf("Expected %s to be fetched, got %s", url, fetchedURL)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the fetched handler to be called")
+		return
+	}
+	waitFetch(logos, url)
+	for i := 0; i < 2; i++ {
+		name, err := logos.Fetch("ns1_app_favicon.ico", url)
+		if err != nil || name != "ns1_app_favicon.ico" {
+			t.Errorf("Expected ns1_app_favicon.ico, got %s, %v", name, err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Expected the logo to be fetched once, got %d requests", n)
+	}
+	if content, err := fs.ReadFile("/var/lib/lemonldap-ng/conf/ns1_app_favicon.ico"); err != nil || string(content) != "ICO" {
+		t.Errorf("Expected favicon content, got %q, %v", content, err)
+	}
+
+	// Expired logos are still used while fetched again
+	logos.Lock()
+	logos.fetched[url] = fetchedLogo{fileName: "ns1_app_favicon.ico", fetchedAt: time.Now().Add(-2 * LogoFetchPeriod)}
+	logos.Unlock()
+	if name, err := logos.Fetch("ns1_app_favicon.ico", url); err != nil || name != "ns1_app_favicon.ico" {
+		t.Errorf("Expected expired ns1_app_favicon.ico, got %s, %v", name, err)
+	}
+	waitFetch(logos, url)
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("Expected the expired logo to be fetched again, got %d requests", n)
+	}
+	select {
+	case fetchedURL := <-fetched:
+		t.Errorf("Expected the fetched handler to be called once, got %s", fetchedURL)
+	default:
+	}
+
+	for _, c := range []struct {
+		name     string
+		expected string
+	}{
+		{"missing.ico", "404 Not Found"},
+		{"large.ico", "larger than"},
+	} {
+		url := server.URL + "/" + c.name
+		logos.Fetch(c.name, url)
+		waitFetch(logos, url)
+		if _, err := logos.Fetch(c.name, url); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("Expected %q error for %s, got %v", c.expected, c.name, err)
+		}
+		waitFetch(logos, url)
+	}
+}
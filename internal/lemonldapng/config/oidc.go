@@ -0,0 +1,234 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ReasonOIDCRelyingPartyConflict is the reason of an OIDC Relying Party
+// ignored because its name or client ID is already used
+const ReasonOIDCRelyingPartyConflict = "OIDCRelyingPartyConflict"
+
+var wordRE = regexp.MustCompile(`^\w+$`)
+
+// OIDCRelyingPartySpec defines the OIDC Relying Party of the oidc-rp
+// annotation
+type OIDCRelyingPartySpec struct {
+	// ClientID defaults to the Relying Party name
+	ClientID string `yaml:"clientID,omitempty"`
+	// Public clients have no client secret
+	Public bool `yaml:"public,omitempty"`
+	// RedirectPaths and PostLogoutRedirectPaths are appended to the URL of
+	// each Ingress host
+	RedirectPaths           []string `yaml:"redirectPaths"`
+	PostLogoutRedirectPaths []string `yaml:"postLogoutRedirectPaths,omitempty"`
+	// Claims are the exported claims, with their session attribute
+	Claims map[string]string `yaml:"claims,omitempty"`
+	// Scopes are the additional scopes, with their claims
+	Scopes map[string][]string `yaml:"scopes,omitempty"`
+	// SecretName is the name of the client secret Secret
+	SecretName string `yaml:"secretName,omitempty"`
+}
+
+// OIDCRelyingParty defines a LemonLDAP::NG OIDC Relying Party
+type OIDCRelyingParty struct {
+	Name                   string
+	ClientID               string
+	ClientSecret           string
+	Public                 bool
+	RedirectURIs           []string
+	PostLogoutRedirectURIs []string
+	// ExportedVars are the claims, with their session attribute
+	ExportedVars map[string]string
+	// ExtraClaims are the additional scopes, with their space-separated claims
+	ExtraClaims map[string]string
+	SecretName  string
+	// Owner is the namespace/name of the Ingress defining the Relying Party
+	Owner string
+}
+
+// NewOIDCRelyingParty creates the OIDC Relying Party name from the oidc-rp
+// annotation, with redirect URIs on the non-wildcard vhosts
+func NewOIDCRelyingParty(name string, in string, vhosts map[string]*VHost) (*OIDCRelyingParty, error) {
+	var spec OIDCRelyingPartySpec
+	if err := yaml.UnmarshalStrict([]byte(in), &spec); err != nil {
+		return nil, err
+	}
+	rp := &OIDCRelyingParty{
+		Name:         name,
+		ClientID:     spec.ClientID,
+		Public:       spec.Public,
+		ExportedVars: spec.Claims,
+		ExtraClaims:  make(map[string]string),
+		SecretName:   spec.SecretName,
+	}
+	if rp.ClientID == "" {
+		rp.ClientID = name
+	}
+	if len(spec.RedirectPaths) == 0 {
+		return nil, fmt.Errorf("redirectPaths is required")
+	}
+	serverNames := []string{}
+	for serverName := range vhosts {
+		if !IsWildcard(serverName) {
+			serverNames = append(serverNames, serverName)
+		}
+	}
+	if len(serverNames) == 0 {
+		return nil, fmt.Errorf("a non-wildcard host is required")
+	}
+	sort.Strings(serverNames)
+	var err error
+	if rp.RedirectURIs, err = hostURIs(vhosts, serverNames, spec.RedirectPaths); err != nil {
+		return nil, err
+	}
+	if rp.PostLogoutRedirectURIs, err = hostURIs(vhosts, serverNames, spec.PostLogoutRedirectPaths); err != nil {
+		return nil, err
+	}
+	for claim, attribute := range spec.Claims {
+		if !wordRE.MatchString(claim) || !wordRE.MatchString(attribute) {
+			return nil, fmt.Errorf("Invalid claim %q (attribute %q): should be word characters", claim, attribute)
+		}
+	}
+	for scope, claims := range spec.Scopes {
+		if !wordRE.MatchString(scope) {
+			return nil, fmt.Errorf("Invalid scope %q: should be word characters", scope)
+		}
+		for _, claim := range claims {
+			if _, ok := spec.Claims[claim]; !ok {
+				return nil, fmt.Errorf("Claim %s of scope %s should be in claims", claim, scope)
+			}
+		}
+		rp.ExtraClaims[scope] = strings.Join(claims, " ")
+	}
+	return rp, nil
+}
+
+// hostURIs returns the URIs of each path on each vhost
+func hostURIs(vhosts map[string]*VHost, serverNames []string, paths []string) ([]string, error) {
+	uris := []string{}
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("Invalid path %q: should start with /", path)
+		}
+	}
+	for _, serverName := range serverNames {
+		for _, path := range paths {
+			uris = append(uris, strings.TrimSuffix(vhosts[serverName].URL(), "/")+path)
+		}
+	}
+	return uris, nil
+}
+
+// toConfig returns the LemonLDAP::NG oidcRPMetaDataOptions entry
+func (rp *OIDCRelyingParty) toConfig() map[string]interface{} {
+	public := 0
+	if rp.Public {
+		public = 1
+	}
+	return map[string]interface{}{
+		"oidcRPMetaDataOptionsClientID":               rp.ClientID,
+		"oidcRPMetaDataOptionsClientSecret":           rp.ClientSecret,
+		"oidcRPMetaDataOptionsPublic":                 public,
+		"oidcRPMetaDataOptionsRedirectUris":           strings.Join(rp.RedirectURIs, " "),
+		"oidcRPMetaDataOptionsPostLogoutRedirectUris": strings.Join(rp.PostLogoutRedirectURIs, " "),
+		"oidcRPMetaDataOptionsIDTokenSignAlg":         "RS256",
+	}
+}
+
+// saveOIDCRelyingParties writes the Relying Parties to conf, except the ones
+// conflicting with the base configuration, another owner or another client ID
+func (c *Config) saveOIDCRelyingParties(conf map[string]interface{}, conflicts map[string]Conflict) error {
+	if len(c.oidcRPs) == 0 {
+		return nil
+	}
+	sections := make(map[string]map[string]interface{})
+	for _, key := range []string{"oidcRPMetaDataOptions", "oidcRPMetaDataExportedVars", "oidcRPMetaDataOptionsExtraClaims"} {
+		section, ok := conf[key].(map[string]interface{})
+		if !ok {
+			if conf[key] != nil {
+				return fmt.Errorf("%s should be a map, got %T", key, conf[key])
+			}
+			section = make(map[string]interface{})
+			conf[key] = section
+		}
+		sections[key] = section
+	}
+	clientIDs := make(map[string]string)
+	for name, options := range sections["oidcRPMetaDataOptions"] {
+		if options, ok := options.(map[string]interface{}); ok {
+			if clientID, ok := options["oidcRPMetaDataOptionsClientID"].(string); ok {
+				clientIDs[clientID] = name
+			}
+		}
+	}
+	names := []string{}
+	for name := range c.oidcRPs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		owners := []string{}
+		for owner := range c.oidcRPs[name] {
+			owners = append(owners, owner)
+		}
+		sortOwners(owners)
+		for _, owner := range owners[1:] {
+			conflicts[owner+" oidc "+name] = Conflict{
+				Owner:   owner,
+				Reason:  ReasonOIDCRelyingPartyConflict,
+				Message: fmt.Sprintf("OIDC Relying Party %s is already defined by %s, ignoring it", name, owners[0]),
+			}
+		}
+		rp := c.oidcRPs[name][owners[0]]
+		message := ""
+		if _, ok := sections["oidcRPMetaDataOptions"][name]; ok {
+			message = fmt.Sprintf("OIDC Relying Party %s is already defined in the base configuration, ignoring it", name)
+		} else if other, ok := clientIDs[rp.ClientID]; ok {
+			message = fmt.Sprintf("Client ID %s of OIDC Relying Party %s is already used by %s, ignoring it", rp.ClientID, name, other)
+		}
+		if message != "" {
+			conflicts[rp.Owner+" oidc "+name] = Conflict{
+				Owner:   rp.Owner,
+				Reason:  ReasonOIDCRelyingPartyConflict,
+				Message: message,
+			}
+			continue
+		}
+		clientIDs[rp.ClientID] = name
+		sections["oidcRPMetaDataOptions"][name] = rp.toConfig()
+		exportedVars := make(map[string]interface{})
+		for claim, attribute := range rp.ExportedVars {
+			exportedVars[claim] = attribute
+		}
+		sections["oidcRPMetaDataExportedVars"][name] = exportedVars
+		if len(rp.ExtraClaims) > 0 {
+			extraClaims := make(map[string]interface{})
+			for scope, claims := range rp.ExtraClaims {
+				extraClaims[scope] = claims
+			}
+			sections["oidcRPMetaDataOptionsExtraClaims"][name] = extraClaims
+		}
+	}
+	return nil
+}
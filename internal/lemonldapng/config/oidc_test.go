@@ -0,0 +1,145 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	fakefs "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem/fake"
+)
+
+func oidcTestVHosts() map[string]*VHost {
+	app := NewVHost("app.example.org", nil, nil)
+	app.TLS = true
+	return map[string]*VHost{
+		"app.example.org": app,
+		"www.example.org": NewVHost("www.example.org", nil, nil),
+		"*.example.org":   NewVHost("*.example.org", nil, nil),
+	}
+}
+
+func TestNewOIDCRelyingParty(t *testing.T) {
+	rp, err := NewOIDCRelyingParty("ns1-app", `
+redirectPaths: [/oauth2/callback]
+postLogoutRedirectPaths: [/]
+claims: {email: mail, groups: groups}
+scopes: {groups: [groups]}
+`, oidcTestVHosts())
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	expected := &OIDCRelyingParty{
+		Name:                   "ns1-app",
+		ClientID:               "ns1-app",
+		RedirectURIs:           []string{"https://app.example.org/oauth2/callback", "http://www.example.org/oauth2/callback"},
+		PostLogoutRedirectURIs: []string{"https://app.example.org/", "http://www.example.org/"},
+		ExportedVars:           map[string]string{"email": "mail", "groups": "groups"},
+		ExtraClaims:            map[string]string{"groups": "groups"},
+	}
+	if !reflect.DeepEqual(rp, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, rp)
+	}
+
+	for in, expected := range map[string]string{
+		`{clientID: app}`:                                              "redirectPaths is required",
+		`{redirectPaths: [callback]}`:                                  `Invalid path "callback": should start with /`,
+		`{redirectPaths: [/cb], claims: {e-mail: mail}}`:               `Invalid claim "e-mail" (attribute "mail"): should be word characters`,
+		`{redirectPaths: [/cb], scopes: {groups: [groups]}}`:           "Claim groups of scope groups should be in claims",
+		`{redirectPaths: [/cb], claims: {a: b}, scopes: {"a b": [a]}}`: `Invalid scope "a b": should be word characters`,
+	} {
+		if _, err = NewOIDCRelyingParty("ns1-app", in, oidcTestVHosts()); err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+	wildcardOnly := map[string]*VHost{"*.example.org": NewVHost("*.example.org", nil, nil)}
+	if _, err = NewOIDCRelyingParty("ns1-app", `{redirectPaths: [/cb]}`, wildcardOnly); err == nil {
+		t.Errorf("Expected non-wildcard host error")
+	}
+}
+
+func TestSaveOIDCRelyingParties(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	fs.WriteFile("/var/lib/lemonldap-ng/conf/lmConf-1.js", []byte(`{
+		"applicationList": {},
+		"cfgNum": 1,
+		"exportedHeaders": {},
+		"locationRules": {},
+		"oidcRPMetaDataOptions": {
+			"manual": {"oidcRPMetaDataOptionsClientID": "legacy"}
+		}
+	}`), 0644)
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	config.AddOIDCRelyingParty(&OIDCRelyingParty{
+		Name:         "ns1-app",
+		ClientID:     "app",
+		ClientSecret: "s3cr3t",
+		RedirectURIs: []string{"https://app.example.org/cb", "https://www.example.org/cb"},
+		ExportedVars: map[string]string{"email": "mail"},
+		ExtraClaims:  map[string]string{"groups": "groups"},
+		Owner:        "ns1/app",
+	})
+	config.AddOIDCRelyingParty(&OIDCRelyingParty{Name: "ns2-app", ClientID: "app", Owner: "ns2/app"})
+	config.AddOIDCRelyingParty(&OIDCRelyingParty{Name: "manual", ClientID: "manual", Owner: "ns3/manual"})
+	config.AddOIDCRelyingParty(&OIDCRelyingParty{Name: "ns4-legacy", ClientID: "legacy", Owner: "ns4/legacy"})
+	other := &OIDCRelyingParty{Name: "ns1-app", ClientID: "other", Owner: "ns5/other"}
+	config.AddOIDCRelyingParty(other)
+	if err := config.Save(); err != nil {
+		t.Errorf("%s", err)
+	}
+	conf, err := config.Load("lmConf-2.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	options := conf["oidcRPMetaDataOptions"].(map[string]interface{})
+	if len(options) != 2 || options["manual"].(map[string]interface{})["oidcRPMetaDataOptionsClientID"] != "legacy" {
+		t.Errorf("Expected the manual and ns1-app Relying Parties, got %v", options)
+	}
+	app := options["ns1-app"].(map[string]interface{})
+	if app["oidcRPMetaDataOptionsClientSecret"] != "s3cr3t" || app["oidcRPMetaDataOptionsRedirectUris"] != "https://app.example.org/cb https://www.example.org/cb" || app["oidcRPMetaDataOptionsPublic"] != float64(0) {
+		t.Errorf("Unexpected ns1-app options %v", app)
+	}
+	if email := conf["oidcRPMetaDataExportedVars"].(map[string]interface{})["ns1-app"].(map[string]interface{})["email"]; email != "mail" {
+		t.Errorf("Expected email claim, got %v", email)
+	}
+	if groups := conf["oidcRPMetaDataOptionsExtraClaims"].(map[string]interface{})["ns1-app"].(map[string]interface{})["groups"]; groups != "groups" {
+		t.Errorf("Expected groups scope, got %v", groups)
+	}
+	expected := map[string]string{
+		"ns2/app":    "Client ID app of OIDC Relying Party ns2-app is already used by ns1-app, ignoring it",
+		"ns3/manual": "OIDC Relying Party manual is already defined in the base configuration, ignoring it",
+		"ns4/legacy": "Client ID legacy of OIDC Relying Party ns4-legacy is already used by manual, ignoring it",
+		"ns5/other":  "OIDC Relying Party ns1-app is already defined by ns1/app, ignoring it",
+	}
+	conflicts := config.Conflicts()
+	if len(conflicts) != len(expected) {
+		t.Errorf("Expected %d conflicts, got %v", len(expected), conflicts)
+	}
+	for _, conflict := range conflicts {
+		if conflict.Reason != ReasonOIDCRelyingPartyConflict || conflict.Message != expected[conflict.Owner] {
+			t.Errorf("Unexpected conflict %+v", conflict)
+		}
+	}
+
+	// Only the Relying Party of the owner is deleted
+	config.DeleteOIDCRelyingParty(other)
+	if config.HasOIDCRelyingParty(other) || !config.HasOIDCRelyingParty(&OIDCRelyingParty{Name: "ns1-app", Owner: "ns1/app"}) {
+		t.Errorf("Expected only the Relying Party of ns5/other to be deleted")
+	}
+}
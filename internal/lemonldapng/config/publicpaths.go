@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IngressPaths is the public-paths entry standing for the Ingress own paths
+const IngressPaths = "ingress-paths"
+
+// PublicPathsLocationRules returns the location rules of the public-paths
+// annotation. ingressPaths replace the "ingress-paths" entry. Rules keys sort
+// before the ordered location rules keys
+func PublicPathsLocationRules(annotations map[string]string, prefix string, ingressPaths []string) (map[string]string, error) {
+	locationRules := make(map[string]string)
+	publicPaths, ok := annotations[prefix+"/public-paths"]
+	if !ok {
+		return locationRules, nil
+	}
+	action := "unprotect"
+	if value, ok := annotations[prefix+"/public-paths-action"]; ok {
+		if value != "unprotect" && value != "skip" {
+			return nil, fmt.Errorf("Invalid public-paths-action %q: should be unprotect or skip", value)
+		}
+		action = value
+	}
+	paths := []string{}
+	seen := make(map[string]bool)
+	for _, path := range strings.FieldsFunc(publicPaths, isPathSeparator) {
+		expanded := []string{path}
+		if path == IngressPaths {
+			expanded = ingressPaths
+		} else if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("Invalid public path %q: should start with / or be %s", path, IngressPaths)
+		}
+		for _, p := range expanded {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	width := len(strconv.Itoa(len(paths)))
+	for i, path := range paths {
+		key := fmt.Sprintf("(?# public %0*d)%s", width, i, PathPrefixRegex(path))
+		locationRules[key] = action
+	}
+	return locationRules, nil
+}
+
+// PathPrefixRegex returns a regex matching URIs under path
+func PathPrefixRegex(path string) string {
+	re := "^" + regexp.QuoteMeta(path)
+	if !strings.HasSuffix(path, "/") {
+		re += "(?:[/?]|$)"
+	}
+	return re
+}
+
+// MergeLocationRules returns a new map with the location rules of both maps
+func MergeLocationRules(a, b map[string]string) (map[string]string, error) {
+	locationRules := make(map[string]string)
+	for k, v := range a {
+		locationRules[k] = v
+	}
+	for k, v := range b {
+		if _, ok := locationRules[k]; ok {
+			return nil, fmt.Errorf("Duplicate location rule %s", k)
+		}
+		locationRules[k] = v
+	}
+	return locationRules, nil
+}
+
+func isPathSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\t' || r == '\n'
+}
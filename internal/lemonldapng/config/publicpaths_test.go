@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestPublicPathsLocationRules(t *testing.T) {
+	prefix := "kubernetes-controller.lemonldap-ng.org"
+	locationRules, err := PublicPathsLocationRules(map[string]string{}, prefix, nil)
+	if err != nil || len(locationRules) != 0 {
+		t.Errorf("Expected no location rules, got %v (%v)", locationRules, err)
+	}
+
+	locationRules, err = PublicPathsLocationRules(map[string]string{
+		prefix + "/public-paths": "/healthz, /static/ ingress-paths /healthz",
+	}, prefix, []string{"/v1.0", "/static/"})
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := map[string]string{
+		`(?# public 0)^/healthz(?:[/?]|$)`: "unprotect",
+		`(?# public 1)^/static/`:           "unprotect",
+		`(?# public 2)^/v1\.0(?:[/?]|$)`:   "unprotect",
+	}
+	if !reflect.DeepEqual(locationRules, expected) {
+		t.Errorf("Expected %v, got %v", expected, locationRules)
+	}
+
+	locationRules, err = PublicPathsLocationRules(map[string]string{
+		prefix + "/public-paths":        "/metrics",
+		prefix + "/public-paths-action": "skip",
+	}, prefix, nil)
+	if err != nil || locationRules[`(?# public 0)^/metrics(?:[/?]|$)`] != "skip" {
+		t.Errorf("Expected skip rule, got %v (%v)", locationRules, err)
+	}
+
+	_, err = PublicPathsLocationRules(map[string]string{prefix + "/public-paths": "healthz"}, prefix, nil)
+	if err == nil || err.Error() != `Invalid public path "healthz": should start with / or be ingress-paths` {
+		t.Errorf("Expected invalid public path error, got %v", err)
+	}
+	_, err = PublicPathsLocationRules(map[string]string{
+		prefix + "/public-paths":        "/healthz",
+		prefix + "/public-paths-action": "accept",
+	}, prefix, nil)
+	if err == nil {
+		t.Errorf("Expected invalid public-paths-action error")
+	}
+}
+
+func TestPublicPathsOrder(t *testing.T) {
+	prefix := "kubernetes-controller.lemonldap-ng.org"
+	public, _ := PublicPathsLocationRules(map[string]string{prefix + "/public-paths": "/healthz"}, prefix, nil)
+	ordered, _ := OrderLocationRules([]LocationRule{{Path: "^/", Rule: "deny"}})
+	locationRules, err := MergeLocationRules(ordered, public)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	locationRules["^/admin/"] = "deny"
+	keys := []string{}
+	for k := range locationRules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	expected := []string{`(?# public 0)^/healthz(?:[/?]|$)`, "(?#0)^/", "^/admin/"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+}
+
+func TestPathPrefixRegex(t *testing.T) {
+	re := regexp.MustCompile(PathPrefixRegex("/healthz"))
+	for uri, match := range map[string]bool{
+		"/healthz":      true,
+		"/healthz/":     true,
+		"/healthz?full": true,
+		"/healthzfoo":   false,
+		"/app/healthz":  false,
+	} {
+		if re.MatchString(uri) != match {
+			t.Errorf("Expected %s match of %s to be %v", re, uri, match)
+		}
+	}
+}
@@ -18,11 +18,24 @@ package config
 
 import (
 	"net/http"
+	"time"
+
+	"github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/metrics"
 )
 
+// ReloadTimeout is the timeout of the LemonLDAP::NG reload request
+const ReloadTimeout = 10 * time.Second
+
+var reloadClient = &http.Client{Timeout: ReloadTimeout}
+
 // ReloadLemonLDAPNG issues an HTTP request to http://localhost/reload
-func (c *Config) ReloadLemonLDAPNG() error {
-	resp, err := http.Get("http://localhost/reload")
+func (c *Config) ReloadLemonLDAPNG() (err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ReloadDuration.Observe(time.Since(start).Seconds())
+		metrics.Reloads.WithLabelValues(metrics.Result(err)).Inc()
+	}()
+	resp, err := reloadClient.Get("http://localhost/reload")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,138 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// closingBrackets are the brackets which must be balanced in rules
+var closingBrackets = map[rune]rune{'(': ')', '[': ']', '{': '}', '<': '>'}
+
+// ValidateRule checks that a LemonLDAP::NG rule is a single Perl expression,
+// with balanced brackets and terminated strings. Regexes after =~ and !~ are
+// skipped. The rule is still evaluated by LemonLDAP::NG in its Safe jail
+func ValidateRule(rule string) error {
+	if strings.TrimSpace(rule) == "" {
+		return fmt.Errorf("empty rule")
+	}
+	runes := []rune(rule)
+	stack := []rune{}
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '\'', '"':
+			end := skipDelimited(runes, i+1, r)
+			if end < 0 {
+				return fmt.Errorf("unterminated string")
+			}
+			i = end
+		case '~':
+			if i == 0 || (runes[i-1] != '=' && runes[i-1] != '!') {
+				continue
+			}
+			end := skipRegex(runes, i+1)
+			if end < 0 {
+				return fmt.Errorf("unterminated regex")
+			}
+			i = end
+		case '(', '[', '{':
+			stack = append(stack, closingBrackets[r])
+		case ')', ']', '}':
+			if len(stack) == 0 || stack[len(stack)-1] != r {
+				return fmt.Errorf("unbalanced %q", r)
+			}
+			stack = stack[:len(stack)-1]
+		case ';':
+			return fmt.Errorf("should be a single expression, without ;")
+		case '`':
+			return fmt.Errorf("commands are not allowed")
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("missing %q", stack[len(stack)-1])
+	}
+	return nil
+}
+
+// skipRegex returns the index of the end of the regex following a =~ or !~
+// operator at start, start-1 when it is not a regex literal (like a
+// variable), or -1 when it is unterminated. Substitutions have two parts
+func skipRegex(runes []rune, start int) int {
+	i := start
+	for i < len(runes) && unicode.IsSpace(runes[i]) {
+		i++
+	}
+	operator := ""
+	for _, prefix := range []string{"qr", "m", "s"} {
+		if strings.HasPrefix(string(runes[i:]), prefix) {
+			operator = prefix
+			break
+		}
+	}
+	i += len(operator)
+	if i >= len(runes) {
+		return start - 1
+	}
+	open := runes[i]
+	if operator == "" && open != '/' {
+		return start - 1
+	}
+	if open == '_' || unicode.IsLetter(open) || unicode.IsDigit(open) || unicode.IsSpace(open) {
+		return start - 1
+	}
+	end := skipDelimited(runes, i+1, open)
+	if end < 0 || operator != "s" {
+		return end
+	}
+	if _, nested := closingBrackets[open]; !nested {
+		return skipDelimited(runes, end+1, open)
+	}
+	i = end + 1
+	for i < len(runes) && unicode.IsSpace(runes[i]) {
+		i++
+	}
+	if i >= len(runes) {
+		return -1
+	}
+	return skipDelimited(runes, i+1, runes[i])
+}
+
+// skipDelimited returns the index of the delimiter closing the literal
+// opened by open before start, or -1. Bracket delimiters nest, like in Perl
+func skipDelimited(runes []rune, start int, open rune) int {
+	closing, nested := closingBrackets[open]
+	if !nested {
+		closing = open
+	}
+	depth := 0
+	for i := start; i < len(runes); i++ {
+		switch {
+		case runes[i] == '\\':
+			i++
+		case nested && runes[i] == open:
+			depth++
+		case runes[i] == closing:
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestValidateRule(t *testing.T) {
+	for _, rule := range []string{
+		"accept",
+		`inGroup('admins') or $uid eq "bart"`,
+		`$uid =~ /^(?:bart|lisa)$/`,
+		`$groups =~ /\badmins\b/ and $hGroups->{'it'}`,
+		`'semi;colon' eq $cn`,
+		`$cn eq 'o\'brien'`,
+		`$cn =~ /o'brien/`,
+		`$uid =~ /^[(]/ or $cn =~ m{;}`,
+		`$mail !~ m{\@example\.(org|com)$}i and $uid =~ $hGroups->{'it'}`,
+		`$cn =~ s/'//gr ne '' and $uid =~ s{[(]} {x}r`,
+	} {
+		if err := ValidateRule(rule); err != nil {
+			t.Errorf("Expected %s to be valid, got %s", rule, err)
+		}
+	}
+	for rule, expected := range map[string]string{
+		"":                               "empty rule",
+		"   ":                            "empty rule",
+		"inGroup('admins'":               `missing ')'`,
+		"$hGroups->{'it')":               `unbalanced ')'`,
+		"1; system('id')":                "should be a single expression, without ;",
+		"`id`":                           "commands are not allowed",
+		`$uid eq 'bart`:                  "unterminated string",
+		`$uid eq "bart\"`:                "unterminated string",
+		`$uid =~ /^bart`:                 "unterminated regex",
+		`$uid =~ /^(bart|lisa)$/ and (1`: `missing ')'`,
+		`$uid =~ s{a}{b`:                 "unterminated regex",
+	} {
+		if err := ValidateRule(rule); err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q for %q, got %v", expected, rule, err)
+		}
+	}
+}
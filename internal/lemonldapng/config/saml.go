@@ -0,0 +1,234 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ReasonSAMLServiceProviderConflict is the reason of a SAML Service Provider
+// ignored because its name or entityID is already used
+const ReasonSAMLServiceProviderConflict = "SAMLServiceProviderConflict"
+
+// SAMLServiceProviderSpec defines the SAML Service Provider of the saml-sp
+// annotation
+type SAMLServiceProviderSpec struct {
+	// Metadata is the <configmap>/<key> holding the SP metadata XML
+	Metadata   string          `yaml:"metadata"`
+	Attributes []SAMLAttribute `yaml:"attributes,omitempty"`
+	// Options are samlSPMetaDataOptions without prefix, like nameIDFormat
+	Options map[string]interface{} `yaml:"options,omitempty"`
+}
+
+// SAMLAttribute defines an attribute exported to a SAML Service Provider
+type SAMLAttribute struct {
+	// Attribute is the session attribute
+	Attribute string `yaml:"attribute"`
+	// Name is the SAML attribute name, the session attribute by default
+	Name         string `yaml:"name,omitempty"`
+	FriendlyName string `yaml:"friendlyName,omitempty"`
+	Format       string `yaml:"format,omitempty"`
+	Mandatory    bool   `yaml:"mandatory,omitempty"`
+}
+
+// SAMLServiceProvider defines a LemonLDAP::NG SAML Service Provider
+type SAMLServiceProvider struct {
+	Name     string
+	EntityID string
+	Metadata string
+	// ExportedAttributes are the LemonLDAP::NG attribute definitions, by
+	// session attribute
+	ExportedAttributes map[string]string
+	Options            map[string]interface{}
+	// Owner is the namespace/name of the Ingress defining the Service Provider
+	Owner string
+}
+
+// samlEntityDescriptor is the part of the SAML metadata checked
+type samlEntityDescriptor struct {
+	XMLName         xml.Name   `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string     `xml:"entityID,attr"`
+	SPSSODescriptor []struct{} `xml:"urn:oasis:names:tc:SAML:2.0:metadata SPSSODescriptor"`
+}
+
+// ParseSAMLServiceProviderSpec parses the saml-sp annotation
+func ParseSAMLServiceProviderSpec(in string) (*SAMLServiceProviderSpec, error) {
+	spec := &SAMLServiceProviderSpec{}
+	if err := yaml.UnmarshalStrict([]byte(in), spec); err != nil {
+		return nil, err
+	}
+	ref := strings.SplitN(spec.Metadata, "/", 2)
+	if len(ref) != 2 || ref[0] == "" || ref[1] == "" {
+		return nil, fmt.Errorf("Invalid metadata %q: should be <configmap>/<key>", spec.Metadata)
+	}
+	return spec, nil
+}
+
+// SAMLEntityID returns the entityID of SAML Service Provider metadata
+func SAMLEntityID(metadata string) (string, error) {
+	var descriptor samlEntityDescriptor
+	if err := xml.Unmarshal([]byte(metadata), &descriptor); err != nil {
+		return "", fmt.Errorf("Invalid SAML metadata: %s", err)
+	}
+	if descriptor.EntityID == "" {
+		return "", fmt.Errorf("Invalid SAML metadata: missing entityID")
+	}
+	if len(descriptor.SPSSODescriptor) == 0 {
+		return "", fmt.Errorf("Invalid SAML metadata: missing SPSSODescriptor")
+	}
+	return descriptor.EntityID, nil
+}
+
+// NewSAMLServiceProvider creates the SAML Service Provider name from spec and
+// its metadata XML
+func NewSAMLServiceProvider(name string, spec *SAMLServiceProviderSpec, metadata string) (*SAMLServiceProvider, error) {
+	entityID, err := SAMLEntityID(metadata)
+	if err != nil {
+		return nil, err
+	}
+	sp := &SAMLServiceProvider{
+		Name:               name,
+		EntityID:           entityID,
+		Metadata:           metadata,
+		ExportedAttributes: make(map[string]string),
+		Options:            make(map[string]interface{}),
+	}
+	for _, attribute := range spec.Attributes {
+		if !wordRE.MatchString(attribute.Attribute) {
+			return nil, fmt.Errorf("Invalid attribute %q: should be word characters", attribute.Attribute)
+		}
+		if _, ok := sp.ExportedAttributes[attribute.Attribute]; ok {
+			return nil, fmt.Errorf("Attribute %s is exported twice", attribute.Attribute)
+		}
+		samlName := attribute.Name
+		if samlName == "" {
+			samlName = attribute.Attribute
+		}
+		for _, field := range []string{samlName, attribute.FriendlyName, attribute.Format} {
+			if strings.Contains(field, ";") {
+				return nil, fmt.Errorf("Invalid attribute %s: %q should not contain ;", attribute.Attribute, field)
+			}
+		}
+		mandatory := "0"
+		if attribute.Mandatory {
+			mandatory = "1"
+		}
+		sp.ExportedAttributes[attribute.Attribute] = strings.Join([]string{mandatory, samlName, attribute.Format, attribute.FriendlyName}, ";")
+	}
+	for option, value := range spec.Options {
+		if !wordRE.MatchString(option) {
+			return nil, fmt.Errorf("Invalid option %q: should be word characters", option)
+		}
+		switch v := value.(type) {
+		case string, int:
+		case bool:
+			value = 0
+			if v {
+				value = 1
+			}
+		default:
+			return nil, fmt.Errorf("Invalid option %s: should be a string, a number or a boolean", option)
+		}
+		sp.Options["samlSPMetaDataOptions"+strings.ToUpper(option[:1])+option[1:]] = value
+	}
+	return sp, nil
+}
+
+// saveSAMLServiceProviders writes the Service Providers to conf, except the
+// ones conflicting with the base configuration, another owner or another
+// entityID
+func (c *Config) saveSAMLServiceProviders(conf map[string]interface{}, conflicts map[string]Conflict) error {
+	if len(c.samlSPs) == 0 {
+		return nil
+	}
+	sections := make(map[string]map[string]interface{})
+	for _, key := range []string{"samlSPMetaDataXML", "samlSPMetaDataExportedAttributes", "samlSPMetaDataOptions"} {
+		section, ok := conf[key].(map[string]interface{})
+		if !ok {
+			if conf[key] != nil {
+				return fmt.Errorf("%s should be a map, got %T", key, conf[key])
+			}
+			section = make(map[string]interface{})
+			conf[key] = section
+		}
+		sections[key] = section
+	}
+	entityIDs := make(map[string]string)
+	for name, section := range sections["samlSPMetaDataXML"] {
+		if section, ok := section.(map[string]interface{}); ok {
+			if metadata, ok := section["samlSPMetaDataXML"].(string); ok {
+				if entityID, err := SAMLEntityID(metadata); err == nil {
+					entityIDs[entityID] = name
+				}
+			}
+		}
+	}
+	names := []string{}
+	for name := range c.samlSPs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		owners := []string{}
+		for owner := range c.samlSPs[name] {
+			owners = append(owners, owner)
+		}
+		sortOwners(owners)
+		for _, owner := range owners[1:] {
+			conflicts[owner+" saml "+name] = Conflict{
+				Owner:   owner,
+				Reason:  ReasonSAMLServiceProviderConflict,
+				Message: fmt.Sprintf("SAML Service Provider %s is already defined by %s, ignoring it", name, owners[0]),
+			}
+		}
+		sp := c.samlSPs[name][owners[0]]
+		message := ""
+		if _, ok := sections["samlSPMetaDataXML"][name]; ok {
+			message = fmt.Sprintf("SAML Service Provider %s is already defined in the base configuration, ignoring it", name)
+		} else if other, ok := entityIDs[sp.EntityID]; ok {
+			message = fmt.Sprintf("entityID %s of SAML Service Provider %s is already used by %s, ignoring it", sp.EntityID, name, other)
+		}
+		if message != "" {
+			conflicts[sp.Owner+" saml "+name] = Conflict{
+				Owner:   sp.Owner,
+				Reason:  ReasonSAMLServiceProviderConflict,
+				Message: message,
+			}
+			continue
+		}
+		entityIDs[sp.EntityID] = name
+		sections["samlSPMetaDataXML"][name] = map[string]interface{}{
+			"samlSPMetaDataXML": sp.Metadata,
+		}
+		exportedAttributes := make(map[string]interface{})
+		for attribute, definition := range sp.ExportedAttributes {
+			exportedAttributes[attribute] = definition
+		}
+		sections["samlSPMetaDataExportedAttributes"][name] = exportedAttributes
+		options := make(map[string]interface{})
+		for option, value := range sp.Options {
+			options[option] = value
+		}
+		sections["samlSPMetaDataOptions"][name] = options
+	}
+	return nil
+}
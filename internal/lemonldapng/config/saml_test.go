@@ -0,0 +1,218 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	fakefs "github.com/lemonldap-ng-controller/lemonldap-ng-controller/internal/filesystem/fake"
+)
+
+func samlTestMetadata(entityID string) string {
+	return fmt.Sprintf(`<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s">
+  <md:SPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://app.example.org/saml/acs" index="0"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>`, entityID)
+}
+
+func TestParseSAMLServiceProviderSpec(t *testing.T) {
+	spec, err := ParseSAMLServiceProviderSpec(`{metadata: app-saml/metadata.xml, options: {nameIDFormat: email}}`)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if spec.Metadata != "app-saml/metadata.xml" || spec.Options["nameIDFormat"] != "email" {
+		t.Errorf("Unexpected spec %+v", spec)
+	}
+	for in, expected := range map[string]string{
+		`{}`:                        `Invalid metadata "": should be <configmap>/<key>`,
+		`{metadata: app-saml}`:      `Invalid metadata "app-saml": should be <configmap>/<key>`,
+		`{metadata: /metadata.xml}`: `Invalid metadata "/metadata.xml": should be <configmap>/<key>`,
+	} {
+		if _, err = ParseSAMLServiceProviderSpec(in); err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+	if _, err = ParseSAMLServiceProviderSpec(`{metadata: a/b, unknown: true}`); err == nil {
+		t.Errorf("Expected unknown field error")
+	}
+}
+
+func TestSAMLEntityID(t *testing.T) {
+	entityID, err := SAMLEntityID(samlTestMetadata("https://app.example.org/saml"))
+	if err != nil || entityID != "https://app.example.org/saml" {
+		t.Errorf("Expected https://app.example.org/saml, got %q (%v)", entityID, err)
+	}
+	for in, expected := range map[string]string{
+		samlTestMetadata(""): "Invalid SAML metadata: missing entityID",
+		`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="idp"><md:IDPSSODescriptor/></md:EntityDescriptor>`: "Invalid SAML metadata: missing SPSSODescriptor",
+		`<EntityDescriptor entityID="sp"><SPSSODescriptor/></EntityDescriptor>`:                                                            "Invalid SAML metadata: expected element <EntityDescriptor> in name space urn:oasis:names:tc:SAML:2.0:metadata but have no name space",
+	} {
+		if _, err = SAMLEntityID(in); err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+	if _, err = SAMLEntityID(`<md:EntityDescriptor`); err == nil {
+		t.Errorf("Expected XML syntax error")
+	}
+}
+
+func TestNewSAMLServiceProvider(t *testing.T) {
+	spec, err := ParseSAMLServiceProviderSpec(`
+metadata: app-saml/metadata.xml
+attributes:
+- attribute: mail
+  name: email
+  friendlyName: Email
+  format: urn:oasis:names:tc:SAML:2.0:attrname-format:basic
+  mandatory: true
+- attribute: cn
+options:
+  nameIDFormat: email
+  signSSOMessage: true
+  sessionNotOnOrAfterTimeout: 72000
+`)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	sp, err := NewSAMLServiceProvider("ns1-app", spec, samlTestMetadata("https://app.example.org/saml"))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	expected := &SAMLServiceProvider{
+		Name:     "ns1-app",
+		EntityID: "https://app.example.org/saml",
+		Metadata: samlTestMetadata("https://app.example.org/saml"),
+		ExportedAttributes: map[string]string{
+			"mail": "1;email;urn:oasis:names:tc:SAML:2.0:attrname-format:basic;Email",
+			"cn":   "0;cn;;",
+		},
+		Options: map[string]interface{}{
+			"samlSPMetaDataOptionsNameIDFormat":               "email",
+			"samlSPMetaDataOptionsSignSSOMessage":             1,
+			"samlSPMetaDataOptionsSessionNotOnOrAfterTimeout": 72000,
+		},
+	}
+	if !reflect.DeepEqual(sp, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, sp)
+	}
+
+	for in, expected := range map[string]string{
+		`{metadata: a/b, attributes: [{attribute: e-mail}]}`:                  `Invalid attribute "e-mail": should be word characters`,
+		`{metadata: a/b, attributes: [{attribute: mail}, {attribute: mail}]}`: "Attribute mail is exported twice",
+		`{metadata: a/b, attributes: [{attribute: mail, name: "a;b"}]}`:       `Invalid attribute mail: "a;b" should not contain ;`,
+		`{metadata: a/b, options: {"name id": email}}`:                        `Invalid option "name id": should be word characters`,
+		`{metadata: a/b, options: {nameIDFormat: [email]}}`:                   "Invalid option nameIDFormat: should be a string, a number or a boolean",
+	} {
+		spec, err = ParseSAMLServiceProviderSpec(in)
+		if err != nil {
+			t.Errorf("%s", err)
+			continue
+		}
+		if _, err = NewSAMLServiceProvider("ns1-app", spec, samlTestMetadata("sp")); err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestSaveSAMLServiceProviders(t *testing.T) {
+	fs := fakefs.NewFilesystem()
+	fs.WriteFile("/var/lib/lemonldap-ng/conf/lmConf-1.js", []byte(fmt.Sprintf(`{
+		"applicationList": {},
+		"cfgNum": 1,
+		"exportedHeaders": {},
+		"locationRules": {},
+		"samlSPMetaDataXML": {
+			"manual": {"samlSPMetaDataXML": %q}
+		}
+	}`, samlTestMetadata("legacy"))), 0644)
+	config := NewConfig(fs, "/var/lib/lemonldap-ng/conf")
+	config.AddSAMLServiceProvider(&SAMLServiceProvider{
+		Name:               "ns1-app",
+		EntityID:           "app",
+		Metadata:           samlTestMetadata("app"),
+		ExportedAttributes: map[string]string{"mail": "1;mail;;"},
+		Options:            map[string]interface{}{"samlSPMetaDataOptionsNameIDFormat": "email"},
+		Owner:              "ns1/app",
+	})
+	config.AddSAMLServiceProvider(&SAMLServiceProvider{Name: "ns2-app", EntityID: "app", Owner: "ns2/app"})
+	config.AddSAMLServiceProvider(&SAMLServiceProvider{Name: "manual", EntityID: "manual", Owner: "ns3/manual"})
+	config.AddSAMLServiceProvider(&SAMLServiceProvider{Name: "ns4-legacy", EntityID: "legacy", Owner: "ns4/legacy"})
+	config.AddSAMLServiceProvider(&SAMLServiceProvider{Name: "ns1-app", EntityID: "other", Owner: "ns5/other"})
+	if err := config.Save(); err != nil {
+		t.Errorf("%s", err)
+	}
+	conf, err := config.Load("lmConf-2.js")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	metadata := conf["samlSPMetaDataXML"].(map[string]interface{})
+	if len(metadata) != 2 || metadata["manual"].(map[string]interface{})["samlSPMetaDataXML"] != samlTestMetadata("legacy") {
+		t.Errorf("Expected the manual and ns1-app Service Providers, got %v", metadata)
+	}
+	if app := metadata["ns1-app"].(map[string]interface{})["samlSPMetaDataXML"]; app != samlTestMetadata("app") {
+		t.Errorf("Unexpected ns1-app metadata %v", app)
+	}
+	if mail := conf["samlSPMetaDataExportedAttributes"].(map[string]interface{})["ns1-app"].(map[string]interface{})["mail"]; mail != "1;mail;;" {
+		t.Errorf("Expected mail attribute, got %v", mail)
+	}
+	if format := conf["samlSPMetaDataOptions"].(map[string]interface{})["ns1-app"].(map[string]interface{})["samlSPMetaDataOptionsNameIDFormat"]; format != "email" {
+		t.Errorf("Expected email NameID format, got %v", format)
+	}
+	expected := map[string]string{
+		"ns2/app":    "entityID app of SAML Service Provider ns2-app is already used by ns1-app, ignoring it",
+		"ns3/manual": "SAML Service Provider manual is already defined in the base configuration, ignoring it",
+		"ns4/legacy": "entityID legacy of SAML Service Provider ns4-legacy is already used by manual, ignoring it",
+		"ns5/other":  "SAML Service Provider ns1-app is already defined by ns1/app, ignoring it",
+	}
+	conflicts := config.Conflicts()
+	if len(conflicts) != len(expected) {
+		t.Errorf("Expected %d conflicts, got %v", len(expected), conflicts)
+	}
+	for _, conflict := range conflicts {
+		if conflict.Reason != ReasonSAMLServiceProviderConflict || conflict.Message != expected[conflict.Owner] {
+			t.Errorf("Unexpected conflict %+v", conflict)
+		}
+	}
+}
+
+func TestAddSAMLServiceProvider(t *testing.T) {
+	config := NewConfig(fakefs.NewFilesystem(), "/var/lib/lemonldap-ng/conf")
+	config.AddSAMLServiceProvider(&SAMLServiceProvider{Name: "ns1-app", EntityID: "app", Owner: "ns1/app"})
+	config.dirty = false
+	config.AddSAMLServiceProvider(&SAMLServiceProvider{Name: "ns1-app", EntityID: "app", Owner: "ns1/app"})
+	config.DeleteSAMLServiceProvider("ns2-app", "ns2/app")
+	config.DeleteSAMLServiceProvider("ns1-app", "ns2/app")
+	if config.dirty {
+		t.Errorf("Expected unchanged configuration")
+	}
+	config.AddSAMLServiceProvider(&SAMLServiceProvider{Name: "ns1-app", EntityID: "app2", Owner: "ns1/app"})
+	if !config.dirty || config.samlSPs["ns1-app"]["ns1/app"].EntityID != "app2" {
+		t.Errorf("Expected updated Service Provider, got %+v", config.samlSPs["ns1-app"])
+	}
+	config.DeleteSAMLServiceProvider("ns1-app", "ns1/app")
+	if len(config.samlSPs) != 0 {
+		t.Errorf("Expected no Service Provider, got %v", config.samlSPs)
+	}
+}
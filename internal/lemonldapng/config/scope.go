@@ -0,0 +1,126 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ingress path types
+const (
+	PathTypeExact                  = "Exact"
+	PathTypePrefix                 = "Prefix"
+	PathTypeImplementationSpecific = "ImplementationSpecific"
+)
+
+// ScopedDefaultRule is the default rule of hosts with scoped rules, for URIs
+// outside of the Ingress paths. Without it, LemonLDAP::NG would accept them
+const ScopedDefaultRule = "deny"
+
+// maxScopeRank bounds the path length used to order scoped rules
+const maxScopeRank = 9999
+
+// IngressPath is an Ingress path with its type
+type IngressPath struct {
+	Path     string
+	PathType string
+}
+
+// Regex returns a regex matching the URIs of the Ingress path:
+// Exact matches the path only, Prefix matches the path elements, and
+// ImplementationSpecific matches the string prefix
+func (p IngressPath) Regex() (string, error) {
+	path := p.Path
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("Invalid path %q: should start with /", path)
+	}
+	switch p.PathType {
+	case PathTypeExact:
+		return "^" + regexp.QuoteMeta(path) + `(?:\?|$)`, nil
+	case PathTypePrefix:
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			return "^/", nil
+		}
+		return "^" + regexp.QuoteMeta(path) + "(?:[/?]|$)", nil
+	case "", PathTypeImplementationSpecific:
+		return "^" + regexp.QuoteMeta(path), nil
+	}
+	return "", fmt.Errorf("Invalid path type %q: should be %s, %s or %s", p.PathType, PathTypeExact, PathTypePrefix, PathTypeImplementationSpecific)
+}
+
+// ScopeLocationRules returns location rules restricted to the Ingress paths.
+// Each rule only applies to URIs matching both its key and one of the paths,
+// and the default rule applies to the remaining URIs of the paths. Other URIs
+// get ScopedDefaultRule. Scoped rules sort before unscoped ones, longer paths
+// first
+func ScopeLocationRules(locationRules map[string]string, paths []IngressPath) (map[string]string, error) {
+	if len(paths) == 0 {
+		return locationRules, nil
+	}
+	regexes := make([]string, len(paths))
+	longest := 0
+	for i, path := range paths {
+		regex, err := path.Regex()
+		if err != nil {
+			return nil, err
+		}
+		regexes[i] = regex
+		if len(path.Path) > longest {
+			longest = len(path.Path)
+		}
+	}
+	scope := regexes[0]
+	if len(regexes) > 1 {
+		scope = "(?:" + strings.Join(regexes, "|") + ")"
+	}
+	if longest > maxScopeRank {
+		longest = maxScopeRank
+	}
+	rank := maxScopeRank - longest
+
+	keys := []string{}
+	for key := range locationRules {
+		if key != "default" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if _, ok := locationRules["default"]; ok {
+		keys = append(keys, "default")
+	}
+	width := len(strconv.Itoa(len(keys)))
+	scoped := make(map[string]string)
+	for i, key := range keys {
+		// The lookahead is anchored at the start of the URI: unanchored keys
+		// still match anywhere after it
+		scopedKey := fmt.Sprintf("(?#!scope %04d %0*d)(?=%s)", rank, width, i, scope)
+		if key != "default" {
+			scopedKey += ".*?(?:" + key + ")"
+		}
+		scoped[scopedKey] = locationRules[key]
+	}
+	scoped["default"] = ScopedDefaultRule
+	return scoped, nil
+}
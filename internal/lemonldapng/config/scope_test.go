@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestIngressPathRegex(t *testing.T) {
+	for path, matches := range map[IngressPath]map[string]bool{
+		{Path: "/api", PathType: PathTypeExact}: {
+			"/api": true, "/api?x=1": true, "/api/": false, "/apix": false,
+		},
+		{Path: "/api/", PathType: PathTypePrefix}: {
+			"/api": true, "/api/v1": true, "/api?x=1": true, "/apix": false,
+		},
+		{Path: "/", PathType: PathTypePrefix}: {
+			"/": true, "/api": true,
+		},
+		{Path: "/api", PathType: PathTypeImplementationSpecific}: {
+			"/api": true, "/apix": true, "/ap": false,
+		},
+		{Path: "/v1.0"}: {
+			"/v1.0/": true, "/v100": false,
+		},
+	} {
+		regex, err := path.Regex()
+		if err != nil {
+			t.Errorf("%s", err)
+			continue
+		}
+		re := regexp.MustCompile(regex)
+		for uri, match := range matches {
+			if re.MatchString(uri) != match {
+				t.Errorf("Expected %s match of %s to be %v", regex, uri, match)
+			}
+		}
+	}
+	if _, err := (IngressPath{Path: "/api", PathType: "Regex"}).Regex(); err == nil {
+		t.Errorf("Expected invalid path type error")
+	}
+	if _, err := (IngressPath{Path: "api"}).Regex(); err == nil {
+		t.Errorf("Expected invalid path error")
+	}
+}
+
+func TestScopeLocationRules(t *testing.T) {
+	locationRules := map[string]string{
+		"^/api/admin/": "inGroup('admins')",
+		"/private":     "inGroup('staff')",
+		"default":      "accept",
+	}
+	scoped, err := ScopeLocationRules(locationRules, []IngressPath{{Path: "/api", PathType: PathTypePrefix}})
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := map[string]string{
+		`(?#!scope 9995 0)(?=^/api(?:[/?]|$)).*?(?:/private)`:     "inGroup('staff')",
+		`(?#!scope 9995 1)(?=^/api(?:[/?]|$)).*?(?:^/api/admin/)`: "inGroup('admins')",
+		`(?#!scope 9995 2)(?=^/api(?:[/?]|$))`:                    "accept",
+		"default":                                                 "deny",
+	}
+	if !reflect.DeepEqual(scoped, expected) {
+		t.Errorf("Expected %v, got %v", expected, scoped)
+	}
+	// Without the lookahead, which is anchored, scoped keys match like the
+	// original ones
+	for key, matches := range map[string]map[string]bool{
+		`(?#!scope 9995 0)(?=^/api(?:[/?]|$)).*?(?:/private)`:     {"/api/private": true, "/api/v1/private/x": true, "/api/public": false},
+		`(?#!scope 9995 1)(?=^/api(?:[/?]|$)).*?(?:^/api/admin/)`: {"/api/admin/": true, "/api/v1/api/admin/": false},
+	} {
+		re := regexp.MustCompile("^" + key[strings.Index(key, ")).*?")+2:])
+		for uri, match := range matches {
+			if re.MatchString(uri) != match {
+				t.Errorf("Expected %s match of %s to be %v", key, uri, match)
+			}
+		}
+	}
+
+	scoped, err = ScopeLocationRules(map[string]string{"default": "deny"}, []IngressPath{
+		{Path: "/web", PathType: PathTypeExact},
+		{Path: "/static", PathType: PathTypePrefix},
+	})
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	expected = map[string]string{
+		`(?#!scope 9992 0)(?=(?:^/web(?:\?|$)|^/static(?:[/?]|$)))`: "deny",
+		"default": "deny",
+	}
+	if !reflect.DeepEqual(scoped, expected) {
+		t.Errorf("Expected %v, got %v", expected, scoped)
+	}
+
+	// URIs outside of the paths are denied, even without default rule
+	scoped, err = ScopeLocationRules(map[string]string{"^/api/admin/": "inGroup('admins')"}, []IngressPath{{Path: "/api"}})
+	if err != nil || scoped["default"] != ScopedDefaultRule {
+		t.Errorf("Expected default rule %s, got %v (%v)", ScopedDefaultRule, scoped, err)
+	}
+
+	scoped, err = ScopeLocationRules(locationRules, nil)
+	if err != nil || !reflect.DeepEqual(scoped, locationRules) {
+		t.Errorf("Expected unchanged location rules, got %v (%v)", scoped, err)
+	}
+}
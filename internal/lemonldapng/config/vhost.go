@@ -16,11 +16,46 @@ limitations under the License.
 
 package config
 
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
 // VHost defines a LemonLDAP::NG virtual host
 type VHost struct {
-	ServerName      string
+	ServerName string
+	// Owner identifies the source of the virtual host, like the Ingress
+	// namespace/name
+	Owner string
+	// Shared virtual hosts, with scoped rules, are merged when all owners
+	// share them. Otherwise, the first owner is kept
+	Shared          bool
 	LocationRules   map[string]string
 	ExportedHeaders map[string]string
+	Options         VHostOptions
+	// TLS is true when the Ingress has a TLS section for this host
+	TLS bool
+	// TLSSecret is the namespace/name of the Secret holding the certificate
+	TLSSecret string
+	// FormReplays are the form replay (post) targets
+	FormReplays []FormReplay
+}
+
+// VHostOptions defines LemonLDAP::NG virtual host options
+type VHostOptions struct {
+	// Port is the port used to build redirection URLs, -1 for auto
+	Port int
+	// HTTPS is 1 to build https redirection URLs, 0 for http, -1 for auto
+	HTTPS       int
+	Maintenance bool
+	Aliases     []string
+	Type        string
+	// AuthnLevel is the minimum authentication level, 0 when not set
+	AuthnLevel int
 }
 
 // DefaultLocationRules is the default location rules when not set
@@ -33,11 +68,192 @@ var DefaultExportedHeaders = map[string]string{
 	"Auth-User": "$uid",
 }
 
+// DefaultVHostOptions returns the default virtual host options
+func DefaultVHostOptions() VHostOptions {
+	return VHostOptions{
+		Port:  -1,
+		HTTPS: -1,
+		Type:  "Main",
+	}
+}
+
 // NewVHost creates a new LemonLDAP::NG virtual host
 func NewVHost(serverName string, locationRules, exportedHeaders map[string]string) *VHost {
 	return &VHost{
 		ServerName:      serverName,
 		LocationRules:   locationRules,
 		ExportedHeaders: exportedHeaders,
+		Options:         DefaultVHostOptions(),
+	}
+}
+
+// NewVHostOptions creates LemonLDAP::NG virtual host options from annotations.
+// When tls is true, vhostHttps and vhostPort default to 1 and 443
+func NewVHostOptions(annotations map[string]string, prefix string, tls bool) (VHostOptions, error) {
+	options := DefaultVHostOptions()
+	if tls {
+		options.HTTPS = 1
+		options.Port = 443
+	}
+	if port, ok := annotations[prefix+"/vhost-port"]; ok {
+		value, err := strconv.Atoi(port)
+		if err != nil || value == 0 || value < -1 || value > 65535 {
+			return options, fmt.Errorf("Invalid vhost-port %q: should be a port number from 1 to 65535, or -1", port)
+		}
+		options.Port = value
+	}
+	if https, ok := annotations[prefix+"/vhost-https"]; ok {
+		if https == "auto" {
+			options.HTTPS = -1
+		} else {
+			value, err := strconv.ParseBool(https)
+			if err != nil {
+				return options, fmt.Errorf("Invalid vhost-https %q: should be true, false or auto", https)
+			}
+			options.HTTPS = boolToInt(value)
+		}
+	}
+	if maintenance, ok := annotations[prefix+"/vhost-maintenance"]; ok {
+		value, err := strconv.ParseBool(maintenance)
+		if err != nil {
+			return options, fmt.Errorf("Invalid vhost-maintenance %q: should be true or false", maintenance)
+		}
+		options.Maintenance = value
+	}
+	if aliases, ok := annotations[prefix+"/vhost-aliases"]; ok {
+		options.Aliases = strings.FieldsFunc(aliases, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	if vhostType, ok := annotations[prefix+"/vhost-type"]; ok {
+		if vhostType == "" {
+			return options, fmt.Errorf("Invalid vhost-type: should not be empty")
+		}
+		options.Type = vhostType
+	}
+	if authnLevel, ok := annotations[prefix+"/vhost-authn-level"]; ok {
+		value, err := strconv.Atoi(authnLevel)
+		if err != nil || value < 0 {
+			return options, fmt.Errorf("Invalid vhost-authn-level %q: should be a positive integer", authnLevel)
+		}
+		options.AuthnLevel = value
+	}
+	return options, nil
+}
+
+// URL returns the root URL of the virtual host
+func (v *VHost) URL() string {
+	scheme := "http"
+	if v.Options.HTTPS == 1 || (v.Options.HTTPS == -1 && v.TLS) {
+		scheme = "https"
+	}
+	port := ""
+	if v.Options.Port > 0 && !(scheme == "http" && v.Options.Port == 80) && !(scheme == "https" && v.Options.Port == 443) {
+		port = ":" + strconv.Itoa(v.Options.Port)
+	}
+	return scheme + "://" + v.ServerName + port + "/"
+}
+
+// firstOwner returns the virtual host of the first owner in name order,
+// reporting the others as conflicts
+func firstOwner(owners map[string]*VHost, conflicts map[string]Conflict) map[string]*VHost {
+	names := []string{}
+	for owner := range owners {
+		names = append(names, owner)
+	}
+	sort.Strings(names)
+	for _, owner := range names[1:] {
+		serverName := owners[owner].ServerName
+		conflicts[owner+" vhost "+serverName] = Conflict{
+			Owner:   owner,
+			Reason:  ReasonVHostConflict,
+			Message: fmt.Sprintf("Virtual host %s is already defined by %s, ignoring it", serverName, names[0]),
+		}
+	}
+	return map[string]*VHost{names[0]: owners[names[0]]}
+}
+
+// allShared returns true when all owners share the virtual host
+func allShared(owners map[string]*VHost) bool {
+	for _, vhost := range owners {
+		if !vhost.Shared {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeVHosts merges the virtual hosts of one server name, indexed by owner.
+// Conflicting location rules and exported headers are taken from the first
+// owner in name order, as well as the options
+func mergeVHosts(owners map[string]*VHost) *VHost {
+	names := []string{}
+	for owner := range owners {
+		names = append(names, owner)
+	}
+	sort.Strings(names)
+	first := owners[names[0]]
+	if len(names) == 1 {
+		return first
+	}
+	merged := *first
+	merged.Owner = ""
+	merged.LocationRules = make(map[string]string)
+	merged.ExportedHeaders = make(map[string]string)
+	merged.FormReplays = append([]FormReplay{}, first.FormReplays...)
+	ruleOwners := make(map[string]string)
+	headerOwners := make(map[string]string)
+	for _, owner := range names {
+		vhost := owners[owner]
+		for k, v := range vhost.LocationRules {
+			if o, ok := ruleOwners[k]; ok {
+				if merged.LocationRules[k] != v {
+					glog.Warningf("Location rule %s of %s conflicts with %s on %s, ignoring it", k, owner, o, vhost.ServerName)
+				}
+				continue
+			}
+			ruleOwners[k] = owner
+			merged.LocationRules[k] = v
+		}
+		for k, v := range vhost.ExportedHeaders {
+			if o, ok := headerOwners[k]; ok {
+				if merged.ExportedHeaders[k] != v {
+					glog.Warningf("Exported header %s of %s conflicts with %s on %s, ignoring it", k, owner, o, vhost.ServerName)
+				}
+				continue
+			}
+			headerOwners[k] = owner
+			merged.ExportedHeaders[k] = v
+		}
+		merged.TLS = merged.TLS && vhost.TLS
+		if owner != names[0] {
+			merged.FormReplays = append(merged.FormReplays, vhost.FormReplays...)
+		}
+	}
+	if _, ok := merged.LocationRules["default"]; !ok {
+		merged.LocationRules["default"] = ScopedDefaultRule
+	}
+	return &merged
+}
+
+// toConfig returns the options as LemonLDAP::NG vhostOptions
+func (o VHostOptions) toConfig() map[string]interface{} {
+	conf := map[string]interface{}{
+		"vhostPort":        o.Port,
+		"vhostHttps":       o.HTTPS,
+		"vhostMaintenance": boolToInt(o.Maintenance),
+		"vhostAliases":     strings.Join(o.Aliases, " "),
+		"vhostType":        o.Type,
+	}
+	if o.AuthnLevel > 0 {
+		conf["vhostAuthnLevel"] = o.AuthnLevel
+	}
+	return conf
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,212 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Format is a log output format
+type Format string
+
+const (
+	// FormatText outputs one key=value record per line
+	FormatText Format = "text"
+	// FormatJSON outputs one JSON record per line
+	FormatJSON Format = "json"
+)
+
+const (
+	// SourceLemonLDAPNG tags records from the LemonLDAP::NG process
+	SourceLemonLDAPNG = "lemonldap-ng"
+	// SourceController tags records from the controller itself
+	SourceController = "controller"
+)
+
+// maxLineLength is the length after which a line is emitted even without a
+// line feed
+const maxLineLength = 64 * 1024
+
+var (
+	// stderr is the standard error before any redirection
+	stderr = os.Stderr
+
+	llngLineRE = regexp.MustCompile(`^\[(debug|info|notice|warn|warning|error|crit|alert|emerg)\]\s*(.*)$`)
+	glogLineRE = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}\.\d{6}\s+\d+ ([^\]]+)\] (.*)$`)
+
+	glogLevels = map[string]string{
+		"I": "info",
+		"W": "warning",
+		"E": "error",
+		"F": "fatal",
+	}
+)
+
+// ParseFormat returns the Format named s
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case FormatText, FormatJSON:
+		return Format(s), nil
+	}
+	return "", fmt.Errorf("Unsupported log format %q, use %s or %s", s, FormatText, FormatJSON)
+}
+
+// Stderr returns the standard error before any redirection
+func Stderr() *os.File {
+	return stderr
+}
+
+// Record is a structured log record
+type Record struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Source  string    `json:"source"`
+	Caller  string    `json:"caller,omitempty"`
+	Message string    `json:"msg"`
+}
+
+// Logger writes structured log records
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format Format
+}
+
+// NewLogger creates a new Logger writing to out
+func NewLogger(out io.Writer, format Format) *Logger {
+	return &Logger{
+		out:    out,
+		format: format,
+	}
+}
+
+// Log writes a record
+func (l *Logger) Log(r Record) {
+	var line []byte
+	if l.format == FormatJSON {
+		line, _ = json.Marshal(r)
+	} else {
+		line = []byte(fmt.Sprintf("time=%s level=%s source=%s", r.Time.Format(time.RFC3339Nano), r.Level, r.Source))
+		if r.Caller != "" {
+			line = append(line, fmt.Sprintf(" caller=%s", r.Caller)...)
+		}
+		line = append(line, fmt.Sprintf(" msg=%s", strconv.Quote(r.Message))...)
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
+
+// ParseLemonLDAPNGLine returns the record of a LemonLDAP::NG log line. Lines
+// without level get defaultLevel
+func ParseLemonLDAPNGLine(line string, defaultLevel string) Record {
+	r := Record{
+		Time:    time.Now(),
+		Level:   defaultLevel,
+		Source:  SourceLemonLDAPNG,
+		Message: line,
+	}
+	if m := llngLineRE.FindStringSubmatch(line); m != nil {
+		r.Level = m[1]
+		if r.Level == "warn" {
+			r.Level = "warning"
+		}
+		r.Message = m[2]
+	}
+	return r
+}
+
+// ParseGlogLine returns the record of a glog line. Lines without glog header
+// get defaultLevel
+func ParseGlogLine(line string, defaultLevel string) Record {
+	r := Record{
+		Time:    time.Now(),
+		Level:   defaultLevel,
+		Source:  SourceController,
+		Message: line,
+	}
+	if m := glogLineRE.FindStringSubmatch(line); m != nil {
+		r.Level = glogLevels[m[1]]
+		r.Caller = m[2]
+		r.Message = m[3]
+	}
+	return r
+}
+
+// LineWriter is an io.Writer which logs each written line as a record
+type LineWriter struct {
+	mu           sync.Mutex
+	logger       *Logger
+	parse        func(line string, defaultLevel string) Record
+	defaultLevel string
+	buf          []byte
+}
+
+// NewLineWriter creates a new LineWriter. Lines are parsed with parse
+func NewLineWriter(logger *Logger, parse func(string, string) Record, defaultLevel string) *LineWriter {
+	return &LineWriter{
+		logger:       logger,
+		parse:        parse,
+		defaultLevel: defaultLevel,
+	}
+}
+
+// Write logs each complete line of p, and buffers the remaining
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLineLength {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Flush logs the buffered incomplete line, if any
+func (w *LineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *LineWriter) emit(line []byte) {
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	w.logger.Log(w.parse(string(line), w.defaultLevel))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range []string{"text", "json"} {
+		if _, err := ParseFormat(format); err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+	_, err := ParseFormat("xml")
+	if err == nil || err.Error() != `Unsupported log format "xml", use text or json` {
+		t.Errorf("Expected unsupported log format error, got %q", err)
+	}
+}
+
+func TestParseLemonLDAPNGLine(t *testing.T) {
+	for line, expected := range map[string][2]string{
+		"[notice] Configuration 3 loaded": {"notice", "Configuration 3 loaded"},
+		"[warn] Session expired":          {"warning", "Session expired"},
+		"[error]No such user":             {"error", "No such user"},
+		"FastCGI: manager initialized":    {"info", "FastCGI: manager initialized"},
+		"[unknown] message":               {"info", "[unknown] message"},
+	} {
+		r := ParseLemonLDAPNGLine(line, "info")
+		if r.Level != expected[0] || r.Message != expected[1] || r.Source != SourceLemonLDAPNG {
+			t.Errorf("Expected level %q and message %q for %q, got %+v", expected[0], expected[1], line, r)
+		}
+	}
+}
+
+func TestParseGlogLine(t *testing.T) {
+	r := ParseGlogLine("W0301 10:00:00.123456    1234 ingresses.go:96] Something happened", "info")
+	if r.Level != "warning" || r.Caller != "ingresses.go:96" || r.Message != "Something happened" || r.Source != SourceController {
+		t.Errorf("Unexpected record %+v", r)
+	}
+	r = ParseGlogLine("panic: oops", "error")
+	if r.Level != "error" || r.Caller != "" || r.Message != "panic: oops" {
+		t.Errorf("Unexpected record %+v", r)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	for format, re := range map[Format]*regexp.Regexp{
+		FormatText: regexp.MustCompile(`^time=\S+ level=notice source=lemonldap-ng msg="first \\"line\\""\ntime=\S+ level=info source=lemonldap-ng msg="second line"\ntime=\S+ level=info source=lemonldap-ng msg="incomplete"\n$`),
+		FormatJSON: regexp.MustCompile(`^{"time":"[^"]+","level":"notice","source":"lemonldap-ng","msg":"first \\"line\\""}\n{"time":"[^"]+","level":"info","source":"lemonldap-ng","msg":"second line"}\n{"time":"[^"]+","level":"info","source":"lemonldap-ng","msg":"incomplete"}\n$`),
+	} {
+		var out bytes.Buffer
+		w := NewLineWriter(NewLogger(&out, format), ParseLemonLDAPNGLine, "info")
+		w.Write([]byte("[notice] first \"line\"\nsecond"))
+		w.Write([]byte(" line\r\nincomplete"))
+		w.Flush()
+		if !re.Match(out.Bytes()) {
+			t.Errorf("Expected %s output to match %s, got:\n%s", format, re, out.String())
+		}
+	}
+}
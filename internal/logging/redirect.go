@@ -0,0 +1,58 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"io"
+	"os"
+)
+
+// Redirect captures the controller standard error (glog output) and logs
+// each line as a record
+type Redirect struct {
+	w    *os.File
+	done chan struct{}
+}
+
+// RedirectStderr replaces os.Stderr with a pipe whose lines are logged to
+// logger as controller records
+func RedirectStderr(logger *Logger) (*Redirect, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	redirect := &Redirect{
+		w:    w,
+		done: make(chan struct{}),
+	}
+	lineWriter := NewLineWriter(logger, ParseGlogLine, "info")
+	go func() {
+		io.Copy(lineWriter, r)
+		lineWriter.Flush()
+		r.Close()
+		close(redirect.done)
+	}()
+	os.Stderr = w
+	return redirect, nil
+}
+
+// Close restores os.Stderr and waits until all captured lines are logged
+func (r *Redirect) Close() {
+	os.Stderr = stderr
+	r.w.Close()
+	<-r.done
+}
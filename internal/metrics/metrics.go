@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "lemonldap_ng_controller"
+
+var (
+	// ConfigSaves counts the LemonLDAP::NG configuration saves, by result
+	ConfigSaves = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_saves_total",
+		Help:      "Number of LemonLDAP::NG configuration saves, by result",
+	}, []string{"result"})
+
+	// ConfigSaveDuration observes the LemonLDAP::NG configuration save latency
+	ConfigSaveDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "config_save_duration_seconds",
+		Help:      "LemonLDAP::NG configuration save latency",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// Reloads counts the LemonLDAP::NG reload attempts, by result
+	Reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reloads_total",
+		Help:      "Number of LemonLDAP::NG reload attempts, by result",
+	}, []string{"result"})
+
+	// ReloadDuration observes the LemonLDAP::NG reload latency
+	ReloadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reload_duration_seconds",
+		Help:      "LemonLDAP::NG reload latency",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// AnnotationParseErrors counts the Ingresses ignored because of invalid
+	// annotations, by namespace
+	AnnotationParseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "annotation_parse_errors_total",
+		Help:      "Number of Ingress annotations parse failures, by namespace",
+	}, []string{"namespace"})
+
+	// ConfigNumber is the current LemonLDAP::NG configuration number
+	ConfigNumber = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_number",
+		Help:      "Current LemonLDAP::NG configuration number (cfgNum)",
+	})
+
+	// VHosts is the number of configured virtual hosts
+	VHosts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "vhosts",
+		Help:      "Number of LemonLDAP::NG virtual hosts configured from Ingresses",
+	})
+
+	// Applications is the number of configured portal applications
+	Applications = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "applications",
+		Help:      "Number of LemonLDAP::NG portal applications configured from Ingresses",
+	})
+
+	// ApplicationConflicts is the number of portal applications ignored
+	// because of a conflict
+	ApplicationConflicts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "application_conflicts",
+		Help:      "Number of Ingress portal applications ignored because of a conflict",
+	})
+
+	// ProcessRestarts counts the LemonLDAP::NG process restarts
+	ProcessRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "process_restarts_total",
+		Help:      "Number of LemonLDAP::NG process restarts",
+	})
+)
+
+// Result returns the result label value for err
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func init() {
+	prometheus.MustRegister(
+		ConfigSaves,
+		ConfigSaveDuration,
+		Reloads,
+		ReloadDuration,
+		AnnotationParseErrors,
+		ConfigNumber,
+		VHosts,
+		Applications,
+		ApplicationConflicts,
+		ProcessRestarts,
+	)
+}
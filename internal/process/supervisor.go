@@ -0,0 +1,269 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultMinBackoff is the default delay before the first restart
+	DefaultMinBackoff = time.Second
+	// DefaultMaxBackoff is the default maximum delay between two restarts
+	DefaultMaxBackoff = time.Minute
+	// DefaultCrashLoopThreshold is the default number of consecutive failures
+	// before giving up
+	DefaultCrashLoopThreshold = 5
+	// DefaultGracePeriod is the default delay between SIGTERM and SIGKILL on
+	// shutdown
+	DefaultGracePeriod = 20 * time.Second
+)
+
+// Status is a snapshot of the supervised process state
+type Status struct {
+	Running             bool
+	PID                 int
+	Restarts            int
+	LastExitCode        int
+	ConsecutiveFailures int
+}
+
+// Supervisor runs a command and restarts it with exponential backoff when it
+// exits
+type Supervisor struct {
+	Command []string
+
+	// MinBackoff is the delay before the first restart, doubled after each
+	// consecutive failure
+	MinBackoff time.Duration
+	// MaxBackoff caps the restart delay. A process running longer than
+	// MaxBackoff is considered healthy and resets the backoff
+	MaxBackoff time.Duration
+	// CrashLoopThreshold is the number of consecutive failures after which
+	// the supervisor gives up. Zero means never give up
+	CrashLoopThreshold int
+	// GracePeriod is the delay given to the process group to exit after
+	// SIGTERM, before it is killed with SIGKILL
+	GracePeriod time.Duration
+	// OnRestart, if set, is called before each restart
+	OnRestart func()
+
+	Stdout io.Writer
+	Stderr io.Writer
+
+	mu     sync.RWMutex
+	status Status
+}
+
+// NewSupervisor creates a new Supervisor with default settings
+func NewSupervisor(command []string) *Supervisor {
+	return &Supervisor{
+		Command:            command,
+		MinBackoff:         DefaultMinBackoff,
+		MaxBackoff:         DefaultMaxBackoff,
+		CrashLoopThreshold: DefaultCrashLoopThreshold,
+		GracePeriod:        DefaultGracePeriod,
+		Stdout:             os.Stdout,
+		Stderr:             os.Stderr,
+	}
+}
+
+// Status returns the current process status
+func (s *Supervisor) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+// Run starts the process and restarts it until stopCh is closed, at which
+// point the process is stopped gracefully. Only failures (non-zero exit code
+// or signal) count as consecutive failures. It returns an error when the
+// process is crash looping
+func (s *Supervisor) Run(stopCh <-chan struct{}) error {
+	for {
+		started := time.Now()
+		err := s.runOnce(stopCh)
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+
+		s.mu.Lock()
+		if time.Since(started) >= s.MaxBackoff {
+			s.status.ConsecutiveFailures = 0
+		}
+		if err != nil {
+			s.status.ConsecutiveFailures++
+		}
+		failures := s.status.ConsecutiveFailures
+		s.mu.Unlock()
+
+		if err != nil && s.CrashLoopThreshold > 0 && failures >= s.CrashLoopThreshold {
+			return fmt.Errorf("LemonLDAP::NG process is crash looping (%d consecutive failures): %v", failures, err)
+		}
+
+		backoff := s.backoff(failures)
+		if err != nil {
+			glog.Errorf("LemonLDAP::NG process failed: %v, restarting in %s", err, backoff)
+		} else {
+			glog.Warningf("LemonLDAP::NG process exited, restarting in %s", backoff)
+		}
+		select {
+		case <-stopCh:
+			return nil
+		case <-time.After(backoff):
+		}
+		s.mu.Lock()
+		s.status.Restarts++
+		s.mu.Unlock()
+		if s.OnRestart != nil {
+			s.OnRestart()
+		}
+	}
+}
+
+// backoff returns the delay before the next restart
+func (s *Supervisor) backoff(failures int) time.Duration {
+	backoff := s.MinBackoff
+	for i := 1; i < failures && backoff < s.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.MaxBackoff {
+		backoff = s.MaxBackoff
+	}
+	return backoff
+}
+
+// runOnce starts the process and waits until it exits or stopCh is closed. It
+// returns nil when the process exits with code 0
+func (s *Supervisor) runOnce(stopCh <-chan struct{}) error {
+	cmd := exec.Command(s.Command[0], s.Command[1:]...)
+
+	// put llng-fastcgi-server in another process group to prevent it
+	// to receive signals meant for the controller
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+		Pgid:    0,
+	}
+
+	glog.Info("Starting LemonLDAP::NG process...")
+	cmd.Stdout = s.Stdout
+	cmd.Stderr = s.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start: %v", err)
+	}
+
+	s.mu.Lock()
+	s.status.Running = true
+	s.status.PID = cmd.Process.Pid
+	s.mu.Unlock()
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case <-stopCh:
+		s.stop(cmd, waitCh)
+		return nil
+	case err = <-waitCh:
+	}
+
+	exitCode := s.exited(cmd, err)
+	if err != nil {
+		return fmt.Errorf("exited with code %d: %v", exitCode, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("exited with code %d", exitCode)
+	}
+	return nil
+}
+
+// stop sends SIGTERM to the process group, waits for the grace period, then
+// sends SIGKILL
+func (s *Supervisor) stop(cmd *exec.Cmd, waitCh <-chan error) {
+	pgid := cmd.Process.Pid
+	glog.Infof("Stopping LemonLDAP::NG process group %d...", pgid)
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		glog.Errorf("Unable to send SIGTERM to LemonLDAP::NG process group %d: %v", pgid, err)
+	}
+
+	var err error
+	select {
+	case err = <-waitCh:
+	case <-time.After(s.GracePeriod):
+		glog.Warningf("LemonLDAP::NG process group %d still running after %s, sending SIGKILL", pgid, s.GracePeriod)
+		if errKill := syscall.Kill(-pgid, syscall.SIGKILL); errKill != nil {
+			glog.Errorf("Unable to send SIGKILL to LemonLDAP::NG process group %d: %v", pgid, errKill)
+		}
+		err = <-waitCh
+	}
+
+	s.exited(cmd, err)
+	if cmd.ProcessState != nil {
+		glog.Infof("LemonLDAP::NG process stopped: %s", cmd.ProcessState)
+	} else {
+		glog.Infof("LemonLDAP::NG process stopped: %v", err)
+	}
+}
+
+// exited records the process exit and returns its exit code
+func (s *Supervisor) exited(cmd *exec.Cmd, err error) int {
+	flush(s.Stdout)
+	flush(s.Stderr)
+	exitCode := exitCode(cmd, err)
+	s.mu.Lock()
+	s.status.Running = false
+	s.status.PID = 0
+	s.status.LastExitCode = exitCode
+	s.mu.Unlock()
+	return exitCode
+}
+
+// flush flushes w if it buffers incomplete lines
+func flush(w io.Writer) {
+	if f, ok := w.(interface {
+		Flush()
+	}); ok {
+		f.Flush()
+	}
+}
+
+// exitCode returns the exit code of a finished command, or -1 when unknown
+func exitCode(cmd *exec.Cmd, err error) int {
+	if cmd.ProcessState == nil {
+		return -1
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus()
+	}
+	if err == nil {
+		return 0
+	}
+	return -1
+}
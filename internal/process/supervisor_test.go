@@ -0,0 +1,162 @@
+/*
+Copyright 2018 Mathieu Parent <math.parent@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrashLoop(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	s := NewSupervisor([]string{"/bin/sh", "-c", "exit 3"})
+	s.MinBackoff = 10 * time.Millisecond
+	s.MaxBackoff = 40 * time.Millisecond
+	s.CrashLoopThreshold = 3
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	err := s.Run(stopCh)
+	if err == nil || !strings.Contains(err.Error(), "crash looping (3 consecutive failures)") {
+		t.Errorf("Expected crash loop error, got %q", err)
+	}
+	status := s.Status()
+	if status.Running {
+		t.Errorf("Expected process not to be running")
+	}
+	if status.Restarts != 2 {
+		t.Errorf("Expected 2 restarts, got %d", status.Restarts)
+	}
+	if status.LastExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", status.LastExitCode)
+	}
+}
+
+func TestCleanExit(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	s := NewSupervisor([]string{"/bin/sh", "-c", "exit 0"})
+	s.MinBackoff = 10 * time.Millisecond
+	s.MaxBackoff = 40 * time.Millisecond
+	s.CrashLoopThreshold = 2
+
+	stopCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(stopCh)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(stopCh)
+	if err := <-errCh; err != nil {
+		t.Errorf("Expected no crash loop on clean exits, got %q", err)
+	}
+	status := s.Status()
+	if status.Restarts < 2 {
+		t.Errorf("Expected the process to be restarted, got %d restarts", status.Restarts)
+	}
+	if status.ConsecutiveFailures != 0 {
+		t.Errorf("Expected no failure, got %d", status.ConsecutiveFailures)
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	s := NewSupervisor([]string{"/nonexistent"})
+	s.MinBackoff = 10 * time.Millisecond
+	s.CrashLoopThreshold = 2
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	err := s.Run(stopCh)
+	if err == nil || !strings.Contains(err.Error(), "unable to start") {
+		t.Errorf("Expected start error, got %q", err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	s := NewSupervisor([]string{"/bin/sleep", "10"})
+
+	stopCh := make(chan struct{})
+	errCh := make(chan error)
+	go func() {
+		errCh <- s.Run(stopCh)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if !s.Status().Running {
+		t.Errorf("Expected process to be running")
+	}
+	close(stopCh)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("%s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Supervisor did not stop")
+	}
+	if s.Status().Running {
+		t.Errorf("Expected process to be stopped")
+	}
+}
+
+func TestStopGracePeriod(t *testing.T) {
+	flag.Set("alsologtostderr", "true")
+	s := NewSupervisor([]string{"/bin/sh", "-c", "trap '' TERM; sleep 10"})
+	s.GracePeriod = 200 * time.Millisecond
+
+	stopCh := make(chan struct{})
+	errCh := make(chan error)
+	go func() {
+		errCh <- s.Run(stopCh)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	started := time.Now()
+	close(stopCh)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("%s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Supervisor did not kill the process group")
+	}
+	if elapsed := time.Since(started); elapsed < s.GracePeriod {
+		t.Errorf("Expected SIGKILL after %s, got %s", s.GracePeriod, elapsed)
+	}
+	if status := s.Status(); status.Running || status.LastExitCode != -1 {
+		t.Errorf("Expected process to be killed, got %+v", status)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := NewSupervisor([]string{"/bin/true"})
+	s.MinBackoff = time.Second
+	s.MaxBackoff = 5 * time.Second
+	for failures, expected := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		if backoff := s.backoff(failures); backoff != expected {
+			t.Errorf("Expected backoff %s after %d failures, got %s", expected, failures, backoff)
+		}
+	}
+}